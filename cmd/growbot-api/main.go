@@ -13,6 +13,7 @@ import (
 	"github.com/teamxiv/growbot-api/internal/api"
 	"github.com/teamxiv/growbot-api/internal/config"
 	"github.com/teamxiv/growbot-api/internal/database"
+	"github.com/teamxiv/growbot-api/internal/mail"
 	"gocloud.dev/blob/fileblob"
 
 	"github.com/koding/multiconfig"
@@ -74,6 +75,7 @@ func main() {
 		logger,
 		db,
 		bucket,
+		mail.New(cfg.Mail, logger),
 	)
 
 	go func() {
@@ -0,0 +1,76 @@
+// growbot-care-profiles loads the built-in care profile catalog into the database.
+//
+// Catalog profiles are matched by name, so running this again updates them in place.
+// The catalog is read from the file in the "catalog" environment variable,
+// and the config from the "config" one, like growbot-api.
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/koding/multiconfig"
+	"github.com/sirupsen/logrus"
+	"github.com/teamxiv/growbot-api/internal/config"
+	"github.com/teamxiv/growbot-api/internal/database"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+func main() {
+	file := os.Getenv("catalog")
+	if file == "" {
+		file = "cmd/growbot-care-profiles/care_profiles.json"
+	}
+
+	m := multiconfig.NewWithPath(os.Getenv("config"))
+	cfg := &config.Config{}
+	m.MustLoad(cfg)
+
+	logger := logrus.StandardLogger()
+
+	f, err := os.Open(file)
+	if err != nil {
+		logger.WithError(err).Fatalln("Could not open catalog")
+	}
+	defer f.Close()
+
+	profiles := []models.CareProfile{}
+	if err := json.NewDecoder(f).Decode(&profiles); err != nil {
+		logger.WithError(err).Fatalln("Could not read catalog")
+	}
+
+	db, err := database.NewPostgres(cfg.Database)
+	if err != nil {
+		logger.WithError(err).Fatalln("Unable to connect to the database server")
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Fatalln("Could not start transaction")
+	}
+	defer tx.Rollback()
+
+	for _, p := range profiles {
+		if !models.ValidLight(p.Light) {
+			logger.WithField("name", p.Name).WithField("light", p.Light).Fatalln("Unknown light level")
+		}
+
+		_, err := tx.NamedExec(
+			`insert into care_profiles(name, species, min_moisture, max_moisture, water_volume, light, photo_interval)
+			values (:name, :species, :min_moisture, :max_moisture, :water_volume, :light, :photo_interval)
+			on conflict (name) where user_id is null do update set
+				species = excluded.species, min_moisture = excluded.min_moisture, max_moisture = excluded.max_moisture,
+				water_volume = excluded.water_volume, light = excluded.light, photo_interval = excluded.photo_interval`,
+			p,
+		)
+		if err != nil {
+			logger.WithError(err).WithField("name", p.Name).Fatalln("Could not load care profile")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.WithError(err).Fatalln("Could not commit catalog")
+	}
+
+	logger.WithField("count", len(profiles)).Infoln("Loaded care profile catalog")
+}
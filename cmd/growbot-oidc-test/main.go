@@ -0,0 +1,211 @@
+// growbot-oidc-test is a tiny OpenID Connect issuer for testing the OIDC login flow locally.
+//
+// Every authorization request is approved straight away for the user given on the command line.
+// Point an entry in the `oidc` config at it, e.g. with issuer "http://localhost:9000".
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+var addr = flag.String("addr", "localhost:9000", "http service address")
+var issuer = flag.String("issuer", "http://localhost:9000", "issuer URL, must match the config of growbot-api")
+var subject = flag.String("sub", "test-user", "subject of the logged in user")
+var email = flag.String("email", "test@example.com", "email of the logged in user")
+var verified = flag.Bool("verified", true, "whether the email is verified")
+var forename = flag.String("forename", "Test", "given name of the logged in user")
+var surname = flag.String("surname", "User", "family name of the logged in user")
+
+const keyID = "growbot-oidc-test"
+
+// authRequest is what we remember between /authorize and /token
+type authRequest struct {
+	ClientID      string
+	Nonce         string
+	CodeChallenge string
+}
+
+var codes = make(map[string]authRequest)
+var codesMutex = &sync.Mutex{}
+
+var key *rsa.PrivateKey
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, msg string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             "invalid_grant",
+		"error_description": msg,
+	})
+}
+
+func discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                *issuer,
+		"authorization_endpoint":                *issuer + "/authorize",
+		"token_endpoint":                        *issuer + "/token",
+		"jwks_uri":                              *issuer + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       &key.PublicKey,
+			KeyID:     keyID,
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+		}},
+	})
+}
+
+func authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "expected code_challenge_method S256", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	codesMutex.Lock()
+	codes[code] = authRequest{
+		ClientID:      q.Get("client_id"),
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: q.Get("code_challenge"),
+	}
+	codesMutex.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+
+	log.Printf("authorized %s for client %s", *subject, q.Get("client_id"))
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, err.Error())
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	codesMutex.Lock()
+	req, ok := codes[code]
+	delete(codes, code)
+	codesMutex.Unlock()
+
+	if !ok {
+		tokenError(w, "unknown code")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.CodeChallenge {
+		tokenError(w, "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":            *issuer,
+		"sub":            *subject,
+		"aud":            req.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          req.Nonce,
+		"email":          *email,
+		"email_verified": *verified,
+		"given_name":     *forename,
+		"family_name":    *surname,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	obj, err := signer.Sign(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	idToken, err := obj.CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("issued id_token for %s", *subject)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	var err error
+	key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", discovery)
+	http.HandleFunc("/keys", keys)
+	http.HandleFunc("/authorize", authorize)
+	http.HandleFunc("/token", token)
+
+	log.Printf("mock issuer %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// AdminCheck is a middleware that only lets admins through. Use after SessionCheck.
+func (a *API) AdminCheck(c *gin.Context) {
+	if c.GetString("user_role") != models.UserRoleAdmin {
+		a.error(c, http.StatusForbidden, "Only admins can do that")
+		c.Abort()
+	}
+}
+
+// AdminUserListGet lists users, optionally searching their name and email address with "q"
+func (a *API) AdminUserListGet(c *gin.Context) {
+	input := struct {
+		Query  string `form:"q"`
+		Limit  int    `form:"limit,default=50"`
+		Offset int    `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users := []models.User{}
+	err := a.DB.Select(
+		&users,
+		"select * from users where $1 = '' or email ilike $4 or (forename || ' ' || surname) ilike $4 order by id limit $2 offset $3",
+		input.Query, input.Limit, input.Offset, "%"+escapeLike(input.Query)+"%",
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "admin.users.list", "", "", nil, gin.H{"q": input.Query})
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+	})
+}
+
+// adminUser gets the user with the id in the URL. Responds and returns nil if that fails.
+func (a *API) adminUser(c *gin.Context) *models.User {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return nil
+	}
+
+	var user models.User
+	if err := a.DB.Get(&user, "select * from users where id = $1", id); err != nil {
+		a.error(c, http.StatusNotFound, "User does not exist ("+err.Error()+")")
+		return nil
+	}
+
+	return &user
+}
+
+// AdminUserGet gets a user, along with their robots
+func (a *API) AdminUserGet(c *gin.Context) {
+	user := a.adminUser(c)
+	if user == nil {
+		return
+	}
+
+	robots := []models.Robot{}
+	if err := a.DB.Select(&robots, "select * from robots where user_id = $1", user.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "admin.users.get", "user", strconv.Itoa(user.ID), &user.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":   user,
+		"robots": robots,
+	})
+}
+
+// adminSetActivated activates or deactivates the user in the URL.
+// Deactivated users can't log in, and their sessions and access tokens stop working.
+func (a *API) adminSetActivated(c *gin.Context, activated bool) {
+	user := a.adminUser(c)
+	if user == nil {
+		return
+	}
+
+	if user.ID == c.GetInt("user_id") {
+		a.error(c, http.StatusBadRequest, "You can't change your own account")
+		return
+	}
+
+	_, err := a.DB.Exec("update users set is_activated = $2, updated_at = timezone('utc', now()) where id = $1", user.ID, activated)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	action := "admin.users.deactivate"
+	if activated {
+		action = "admin.users.activate"
+	}
+	a.audit(c, action, "user", strconv.Itoa(user.ID), &user.ID, nil)
+
+	a.Log.WithField("user_id", user.ID).WithField("admin_id", c.GetInt("user_id")).WithField("activated", activated).Infoln("Changed activation of user")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// AdminUserDeactivatePost deactivates a user
+func (a *API) AdminUserDeactivatePost(c *gin.Context) {
+	a.adminSetActivated(c, false)
+}
+
+// AdminUserActivatePost activates a user
+func (a *API) AdminUserActivatePost(c *gin.Context) {
+	a.adminSetActivated(c, true)
+}
+
+// AdminRobotListGet lists all robots, with their state, owner and whether they are connected.
+//
+// Takes an optional "q", matching the robot id, its title, or the email address of its owner.
+func (a *API) AdminRobotListGet(c *gin.Context) {
+	input := struct {
+		Query  string `form:"q"`
+		Limit  int    `form:"limit,default=50"`
+		Offset int    `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	robots := []struct {
+		ID uuid.UUID `json:"id" db:"robot_id"`
+		models.Robot
+		models.RobotState
+		Email     *string `json:"email,omitempty" db:"email"`
+		Connected bool    `json:"is_connected"`
+	}{}
+
+	err := a.DB.Select(
+		&robots,
+		`select r.id as robot_id, r.user_id, r.household_id, r.title, r.created_at, r.updated_at, s.*, u.email
+		from robots as r join robot_state as s on s.id = r.id left join users as u on u.id = r.user_id
+		where $1 = '' or r.id::text ilike $4 || '%' or r.title ilike '%' || $4 || '%' or u.email ilike '%' || $4 || '%'
+		order by r.created_at limit $2 offset $3`,
+		input.Query, input.Limit, input.Offset, escapeLike(input.Query),
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	robotCtxsMutex.Lock()
+	for i := range robots {
+		_, robots[i].Connected = robotCtxs[robots[i].ID]
+	}
+	robotCtxsMutex.Unlock()
+
+	a.audit(c, "admin.robots.list", "", "", nil, gin.H{"q": input.Query})
+
+	c.JSON(http.StatusOK, gin.H{
+		"robots": robots,
+	})
+}
+
+// AdminRobotDelete unregisters a robot from whoever owns it, so that it can be registered again
+func (a *API) AdminRobotDelete(c *gin.Context) {
+	rid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	var previousOwner *int
+	err = a.DB.Get(&previousOwner, "with t as (delete from robot_transfers where robot_id = $1), w as (delete from watering_rules where robot_id = $1) update robots as r set user_id = null, title = '', household_id = null from robots as old where r.id = $1 and old.id = r.id returning old.user_id", rid)
+	if err != nil {
+		a.error(c, http.StatusNotFound, "Robot does not exist ("+err.Error()+")")
+		return
+	}
+
+	a.audit(c, "admin.robots.unregister", "robot", rid.String(), previousOwner, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// robotAlerts gets the alert thresholds and state of the robot, creating the defaults if it has none yet
+func (a *API) robotAlerts(rid uuid.UUID) (*models.RobotAlerts, error) {
+	if _, err := a.DB.Exec("insert into robot_alerts(robot_id) values ($1) on conflict (robot_id) do nothing", rid); err != nil {
+		return nil, err
+	}
+
+	var alerts models.RobotAlerts
+	if err := a.DB.Get(&alerts, "select * from robot_alerts where robot_id = $1", rid); err != nil {
+		return nil, err
+	}
+	return &alerts, nil
+}
+
+// checkRobotAlerts raises or clears the robot's alerts from the levels it has just reported (which can be nil).
+//
+// Watering is paused when the tank is nearly empty (if the robot is set up to do so),
+// and resumed once the water alert has cleared.
+func (a *API) checkRobotAlerts(robot *models.Robot, battery *int, water *int) {
+	alerts, err := a.robotAlerts(robot.ID)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not get robot alerts")
+		return
+	}
+
+	wasPaused := alerts.WateringPaused
+
+	checks := []struct {
+		kind    string
+		level   *int
+		current *int
+		warning int
+		danger  int
+	}{
+		{"water", water, &alerts.WaterAlert, alerts.WaterWarning, alerts.WaterDanger},
+		{"battery", battery, &alerts.BatteryAlert, alerts.BatteryWarning, alerts.BatteryDanger},
+	}
+
+	for _, check := range checks {
+		if check.level == nil {
+			continue
+		}
+
+		alert := models.AlertLevel(*check.current, *check.level, check.warning, check.danger, alerts.Hysteresis)
+		if alert == *check.current {
+			continue
+		}
+
+		a.robotAlertChanged(robot, check.kind, *check.current, alert, *check.level)
+		*check.current = alert
+	}
+
+	if alerts.WaterAlert == models.AlertNone {
+		alerts.WateringPaused = false
+	} else if alerts.PauseWatering && alerts.WaterAlert == models.AlertDanger {
+		alerts.WateringPaused = true
+	}
+
+	_, err = a.DB.NamedExec("update robot_alerts set water_alert = :water_alert, battery_alert = :battery_alert, watering_paused = :watering_paused where robot_id = :robot_id", alerts)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not update robot alerts")
+		return
+	}
+
+	if alerts.WateringPaused == wasPaused {
+		return
+	}
+
+	entry := LogEntry{
+		UserID:   *robot.UserID,
+		Type:     "robot_alert",
+		Message:  "Watering has been paused until the water tank is refilled",
+		Severity: LogSeverityWarning,
+		RobotID:  &robot.ID,
+	}
+	if !alerts.WateringPaused {
+		entry.Message = "Watering has resumed"
+		entry.Severity = LogSeverityInfo
+	}
+
+	a.logRobotAlert(entry, robot.HouseholdID)
+
+	// Send the robot its events again, with or without the watering
+	a.pingRobotEvents(robot.ID, true)
+}
+
+// robotAlertChanged lets the user know that an alert has been raised or cleared.
+// Alerts getting worse or clearing are logged, and every change is sent as ROBOT_ALERT.
+func (a *API) robotAlertChanged(robot *models.Robot, kind string, previous int, alert int, level int) {
+	a.transmitShared(*robot.UserID, robot.HouseholdID, "ROBOT_ALERT", gin.H{
+		"robot_id": robot.ID,
+		"kind":     kind,
+		"alert":    alert,
+		"level":    level,
+	})
+
+	name := "The water tank"
+	if kind == "battery" {
+		name = "The battery"
+	}
+
+	entry := LogEntry{
+		UserID:  *robot.UserID,
+		Type:    "robot_alert",
+		RobotID: &robot.ID,
+	}
+
+	switch {
+	case alert == models.AlertDanger:
+		entry.Message = fmt.Sprintf("%s is nearly empty (%d%%)", name, level)
+		entry.Severity = LogSeverityDanger
+	case alert == models.AlertWarning && previous == models.AlertNone:
+		entry.Message = fmt.Sprintf("%s is running low (%d%%)", name, level)
+		entry.Severity = LogSeverityWarning
+	case alert == models.AlertNone && kind == "battery":
+		entry.Message = fmt.Sprintf("The battery has been recharged (%d%%)", level)
+		entry.Severity = LogSeveritySuccess
+	case alert == models.AlertNone:
+		entry.Message = fmt.Sprintf("The water tank has been refilled (%d%%)", level)
+		entry.Severity = LogSeveritySuccess
+	default:
+		// Going from danger to warning isn't worth logging
+		return
+	}
+
+	a.logRobotAlert(entry, robot.HouseholdID)
+}
+
+func (a *API) logRobotAlert(entry LogEntry, householdID *int) {
+	if err := a.insertLogEntry(&entry); err != nil {
+		a.Log.WithError(err).WithField("rid", entry.RobotID).Warnln("Could not insert log entry for robot alert")
+		return
+	}
+
+	a.transmitShared(entry.UserID, householdID, "CREATE_LOG_ENTRY", entry)
+}
+
+// isWateringPaused returns whether the robot is holding back watering until its tank is refilled
+func (a *API) isWateringPaused(rid uuid.UUID) (bool, error) {
+	var paused bool
+	err := a.DB.Get(&paused, "select exists(select 1 from robot_alerts where robot_id = $1 and watering_paused)", rid)
+	return paused, err
+}
+
+// RobotAlertsGet returns the robot's alert thresholds, and which alerts are currently raised
+func (a *API) RobotAlertsGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	alerts, err := a.robotAlerts(robot.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, alerts)
+}
+
+// RobotAlertsPut sets the robot's alert thresholds (in percent), the "hysteresis",
+// and whether to "pause_watering" when the tank is nearly empty.
+//
+// The new thresholds apply from the next time the robot reports its levels.
+func (a *API) RobotAlertsPut(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	input := struct {
+		WaterWarning   int  `json:"water_warning"`
+		WaterDanger    int  `json:"water_danger"`
+		BatteryWarning int  `json:"battery_warning"`
+		BatteryDanger  int  `json:"battery_danger"`
+		Hysteresis     int  `json:"hysteresis"`
+		PauseWatering  bool `json:"pause_watering"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.WaterDanger < 0 || input.WaterDanger >= input.WaterWarning || input.WaterWarning > 100 ||
+		input.BatteryDanger < 0 || input.BatteryDanger >= input.BatteryWarning || input.BatteryWarning > 100 {
+		a.error(c, http.StatusBadRequest, "Thresholds must be between 0 and 100, with the danger threshold below the warning threshold")
+		return
+	} else if input.Hysteresis < 0 || input.Hysteresis > 50 {
+		a.error(c, http.StatusBadRequest, "hysteresis must be between 0 and 50")
+		return
+	}
+
+	_, err := a.DB.Exec(
+		`insert into robot_alerts(robot_id, water_warning, water_danger, battery_warning, battery_danger, hysteresis, pause_watering) values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (robot_id) do update set water_warning = $2, water_danger = $3, battery_warning = $4, battery_danger = $5, hysteresis = $6, pause_watering = $7,
+			watering_paused = robot_alerts.watering_paused and $7`,
+		robot.ID, input.WaterWarning, input.WaterDanger, input.BatteryWarning, input.BatteryDanger, input.Hysteresis, input.PauseWatering,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Watering that was paused resumes straight away
+	if !input.PauseWatering {
+		a.pingRobotEvents(robot.ID, true)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
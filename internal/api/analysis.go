@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"image"
+	"math"
+	"net/http"
+	"time"
+
+	// Photos from robots are JPEGs or PNGs
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// PhotoAnalysisInterval is how often photos still waiting to be analysed are looked for
+const PhotoAnalysisInterval = time.Second * 30
+
+// PhotoAnalysisBatch is the most photos analysed every PhotoAnalysisInterval
+const PhotoAnalysisBatch = 20
+
+// PhotoAnalysisMaxPixels is the largest photo (width × height) that is analysed.
+// Larger photos are failed before being decoded, as decoding them could use up all of the memory.
+const PhotoAnalysisMaxPixels = 50000000
+
+// analysisSamples is roughly how many pixels of a photo are looked at
+const analysisSamples = 100000
+
+// Plant pixels are those with a hue between yellow and cyan that are neither too grey nor too dark.
+// Soil, pots and walls are mostly brown, grey or white, so fall outside of it.
+const (
+	plantHueMin        = 40.0
+	plantHueGreen      = 70.0
+	plantHueMax        = 170.0
+	plantSaturationMin = 0.2
+	plantValueMin      = 0.15
+)
+
+// photoMetrics is what is learnt about a photo by segmenting the plant out of it
+type photoMetrics struct {
+	Width  int
+	Height int
+
+	// Samples is how many pixels were looked at, of which Green and Yellow are plant
+	Samples int
+	Green   int
+	Yellow  int
+
+	// Step is the distance between sampled pixels
+	Step int
+}
+
+// hsv converts 16-bit colour components to a hue in degrees, and a saturation and value from 0 to 1
+func hsv(r, g, b uint32) (float64, float64, float64) {
+	rf, gf, bf := float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff
+	hi := math.Max(rf, math.Max(gf, bf))
+	lo := math.Min(rf, math.Min(gf, bf))
+	delta := hi - lo
+
+	if hi == 0 || delta == 0 {
+		return 0, 0, hi
+	}
+
+	var h float64
+	switch hi {
+	case rf:
+		h = 60 * math.Mod((gf-bf)/delta, 6)
+	case gf:
+		h = 60 * ((bf-rf)/delta + 2)
+	default:
+		h = 60 * ((rf-gf)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	return h, delta / hi, hi
+}
+
+// segmentPlant counts the plant pixels of the image, sampling pixels evenly across it
+func segmentPlant(img image.Image) photoMetrics {
+	bounds := img.Bounds()
+	m := photoMetrics{Width: bounds.Dx(), Height: bounds.Dy(), Step: 1}
+	for (m.Width/m.Step)*(m.Height/m.Step) > analysisSamples {
+		m.Step++
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += m.Step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += m.Step {
+			m.Samples++
+
+			r, g, b, _ := img.At(x, y).RGBA()
+			h, s, v := hsv(r, g, b)
+			if s < plantSaturationMin || v < plantValueMin || h < plantHueMin || h > plantHueMax {
+				continue
+			}
+
+			if h < plantHueGreen {
+				m.Yellow++
+			} else {
+				m.Green++
+			}
+		}
+	}
+
+	return m
+}
+
+// apply fills in the analysis of the photo from the metrics
+func (m photoMetrics) apply(photo *models.PlantPhoto) {
+	photo.Width = &m.Width
+	photo.Height = &m.Height
+
+	canopy := m.Green + m.Yellow
+	pixels := canopy * m.Step * m.Step
+	if pixels > m.Width*m.Height {
+		pixels = m.Width * m.Height
+	}
+	photo.CanopyPixels = &pixels
+
+	fraction, coverage := 0.0, 0.0
+	if m.Samples > 0 {
+		fraction = float64(canopy) / float64(m.Samples)
+		coverage = float64(m.Green) / float64(m.Samples)
+	}
+	photo.CanopyFraction = &fraction
+	photo.GreenCoverage = &coverage
+
+	if canopy == 0 {
+		return
+	}
+
+	green := float64(m.Green) / float64(canopy)
+	yellow := float64(m.Yellow) / float64(canopy)
+	photo.GreenFraction = &green
+	photo.YellowFraction = &yellow
+
+	// A fully green canopy is perfectly healthy, a fully yellow one is not at all
+	health := int(math.Round(green * 100))
+	photo.ColourHealth = &health
+}
+
+// analysePhotos analyses every photo waiting to be analysed, and then keeps looking for more.
+// Photos left running by a previous run of the server are analysed again.
+func (a *API) analysePhotos() {
+	_, err := a.DB.Exec("update plant_photos set analysis_status = $1 where analysis_status = $2", models.PhotoAnalysisPending, models.PhotoAnalysisRunning)
+	if err != nil {
+		a.Log.WithError(err).Warnln("Could not reset running photo analyses")
+	}
+
+	for {
+		ids := []int{}
+		err := a.DB.Select(
+			&ids,
+			"select id from plant_photos where analysis_status = $1 and archived_at is null order by created_at limit $2",
+			models.PhotoAnalysisPending, PhotoAnalysisBatch,
+		)
+		if err != nil {
+			a.Log.WithError(err).Warnln("Could not get photos to analyse")
+		}
+
+		for _, id := range ids {
+			a.analysePhoto(id)
+		}
+
+		// Keep going straight away while there is a backlog, otherwise wait for a photo to be uploaded
+		if len(ids) < PhotoAnalysisBatch {
+			select {
+			case <-a.photoUploaded:
+			case <-time.After(PhotoAnalysisInterval):
+			}
+		}
+	}
+}
+
+// analysePhoto segments the plant out of the photo and stores what was found, then updates the health of the plant.
+// Does nothing if the photo isn't waiting to be analysed.
+func (a *API) analysePhoto(photoID int) {
+	logger := a.Log.WithField("photo_id", photoID)
+
+	photo := models.PlantPhoto{}
+	err := a.DB.Get(
+		&photo,
+		"update plant_photos set analysis_status = $2 where id = $1 and analysis_status = $3 returning *",
+		photoID, models.PhotoAnalysisRunning, models.PhotoAnalysisPending,
+	)
+	if err != nil {
+		// Someone else got to it first
+		return
+	}
+
+	ok, err := a.photoSizeAllowed(photo.Filename)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not read size of photo to analyse")
+		a.failPhotoAnalysis(photo.ID)
+		return
+	} else if !ok {
+		logger.Warnln("Photo is too large to analyse")
+		a.failPhotoAnalysis(photo.ID)
+		return
+	}
+
+	r, err := a.Bucket.NewReader(context.Background(), photoBucketKey(photo.Filename), nil)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not open photo to analyse")
+		a.failPhotoAnalysis(photo.ID)
+		return
+	}
+	defer r.Close()
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not decode photo to analyse")
+		a.failPhotoAnalysis(photo.ID)
+		return
+	}
+
+	segmentPlant(img).apply(&photo)
+
+	err = a.DB.Get(
+		&photo,
+		`update plant_photos set analysis_status = $2, analysed_at = timezone('utc', now()), width = $3, height = $4,
+		canopy_pixels = $5, canopy_fraction = $6, green_fraction = $7, yellow_fraction = $8, colour_health = $9, green_coverage = $10
+		where id = $1 returning *`,
+		photo.ID, models.PhotoAnalysisDone, photo.Width, photo.Height,
+		photo.CanopyPixels, photo.CanopyFraction, photo.GreenFraction, photo.YellowFraction, photo.ColourHealth, photo.GreenCoverage,
+	)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not store photo analysis")
+		a.failPhotoAnalysis(photo.ID)
+		return
+	}
+
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select user_id, household_id from plants where id = $1", photo.PlantID); err != nil {
+		logger.WithError(err).Warnln("Could not get plant of analysed photo")
+		return
+	}
+
+	a.transmitShared(plant.UserID, plant.HouseholdID, "PHOTO_ANALYSED", photo)
+	a.updatePlantHealth(photo.PlantID)
+}
+
+// photoSizeAllowed reads just the header of the photo, and returns whether it is small enough to decode
+func (a *API) photoSizeAllowed(filename uuid.UUID) (bool, error) {
+	r, err := a.Bucket.NewReader(context.Background(), photoBucketKey(filename), nil)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	conf, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return false, err
+	}
+
+	return conf.Width > 0 && conf.Height > 0 && int64(conf.Width)*int64(conf.Height) <= PhotoAnalysisMaxPixels, nil
+}
+
+// queuePhotoAnalysis wakes up analysePhotos, unless it has already been woken up
+func (a *API) queuePhotoAnalysis() {
+	select {
+	case a.photoUploaded <- struct{}{}:
+	default:
+	}
+}
+
+func (a *API) failPhotoAnalysis(photoID int) {
+	_, err := a.DB.Exec("update plant_photos set analysis_status = $2, analysed_at = timezone('utc', now()) where id = $1", photoID, models.PhotoAnalysisFailed)
+	if err != nil {
+		a.Log.WithError(err).WithField("photo_id", photoID).Warnln("Could not mark photo analysis as failed")
+	}
+}
+
+// PlantGrowthGet returns the growth curve of the plant: the canopy in each of its analysed photos, oldest first,
+// along with how fast it is growing and how many photos are still to be analysed.
+// Takes an optional "days" to look back over, defaulting to 30.
+func (a *API) PlantGrowthGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	days, ok := a.bindUsageDays(c)
+	if !ok {
+		return
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days)
+
+	points := []models.GrowthPoint{}
+	err := a.DB.Select(
+		&points,
+		"select id, created_at, canopy_pixels, canopy_fraction, colour_health from plant_photos where plant_id = $1 and analysis_status = $2 and archived_at is null and created_at > $3 order by created_at",
+		plant.ID, models.PhotoAnalysisDone, since,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var pending int
+	err = a.DB.Get(
+		&pending,
+		"select count(*) from plant_photos where plant_id = $1 and analysis_status in ($2, $3) and archived_at is null and created_at > $4",
+		plant.ID, models.PhotoAnalysisPending, models.PhotoAnalysisRunning, since,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"points":      points,
+		"growth_rate": models.GrowthRate(points),
+		"pending":     pending,
+	})
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"github.com/teamxiv/growbot-api/internal/config"
+	"github.com/teamxiv/growbot-api/internal/mail"
+	"github.com/teamxiv/growbot-api/internal/models"
 	"gocloud.dev/blob"
 )
 
@@ -21,10 +23,17 @@ type API struct {
 	Gin    *gin.Engine
 	DB     *sqlx.DB
 	Bucket *blob.Bucket
+	Mailer mail.Mailer
 
 	Server *http.Server
 
-	userStreams *userStreams
+	authMiddleware *jwt.GinJWTMiddleware
+	userStreams    *userStreams
+	oidc           *oidcProviders
+	limiter        *rateLimiter
+
+	// photoUploaded wakes up analysePhotos when a robot uploads a photo
+	photoUploaded chan struct{}
 }
 
 // Start binds the API and starts listening.
@@ -33,6 +42,14 @@ func (a *API) Start() error {
 		Addr:    a.Config.BindAddress,
 		Handler: a.Gin,
 	}
+
+	go a.cleanupLimiter()
+	go a.cleanupExports()
+	go a.reapTasks()
+	go a.enforceDocking()
+	go a.purgeArchived()
+	go a.analysePhotos()
+
 	return a.Server.ListenAndServe()
 }
 
@@ -69,6 +86,7 @@ func NewAPI(
 	log *logrus.Logger,
 	db *sqlx.DB,
 	bucket *blob.Bucket,
+	mailer mail.Mailer,
 ) *API {
 
 	router := gin.Default()
@@ -86,8 +104,13 @@ func NewAPI(
 		Gin:    router,
 		DB:     db,
 		Bucket: bucket,
+		Mailer: mailer,
 
 		userStreams: newUserStream(),
+		oidc:        newOIDCProviders(conf.OIDC),
+		limiter:     newRateLimiter(conf.RateLimit),
+
+		photoUploaded: make(chan struct{}, 1),
 	}
 
 	// the jwt middleware
@@ -118,6 +141,7 @@ func NewAPI(
 	if err != nil {
 		log.WithField("error", err).Fatal("jwt error")
 	}
+	a.authMiddleware = authMiddleware
 
 	// router.NoRoute(authMiddleware.MiddlewareFunc(), func(c *gin.Context) {
 	// 	claims := jwt.ExtractClaims(c)
@@ -125,8 +149,14 @@ func NewAPI(
 	// 	c.JSON(404, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
 	// })
 
-	// Actual auth middleware
-	authRequired := authMiddleware.MiddlewareFunc()
+	// Actual auth middleware, accepting both JWTs and personal access tokens
+	authRequired := a.TokenAuth(authMiddleware.MiddlewareFunc())
+
+	// Scope checks for routes that don't go through a *Check middleware
+	robotsScope := a.ScopeCheck(models.ScopeRobotsRead, models.ScopeRobotsControl)
+	plantsScope := a.ScopeCheck(models.ScopePlantsRead, models.ScopePlantsWrite)
+	eventsScope := a.ScopeCheck(models.ScopeEventsRead, models.ScopeEventsWrite)
+	logScope := a.ScopeCheck(models.ScopeLogRead, models.ScopeLogRead)
 
 	// Stream
 	{
@@ -143,24 +173,104 @@ func NewAPI(
 	// Authentication
 	auth := router.Group("/auth")
 	{
-		auth.POST("/login", authMiddleware.LoginHandler)
+		auth.POST("/login", a.RateLimit("login"), authMiddleware.LoginHandler)
 		auth.POST("/refresh", authMiddleware.RefreshHandler)
-		auth.POST("/register", a.AuthRegisterPost)
-		auth.POST("/forgot", a.AuthForgotPost)
-		auth.POST("/chgpass", authRequired, a.AuthChgPassPost)
+		auth.POST("/register", a.RateLimit("register"), a.AuthRegisterPost)
+		auth.POST("/forgot", a.RateLimit("forgot"), a.AuthForgotPost)
+		auth.POST("/chgpass", a.RateLimit("chgpass"), authRequired, a.SessionCheck, a.AuthChgPassPost)
+		auth.POST("/email/confirm", a.RateLimit("email"), a.AuthEmailConfirmPost)
+
+		// OpenID Connect
+		auth.GET("/oidc", a.AuthOIDCListGet)
+		auth.GET("/oidc/:provider", a.AuthOIDCStartGet)
+		auth.POST("/oidc/:provider/callback", a.AuthOIDCCallbackPost)
+	}
+
+	// The current user
+	me := router.Group("/me", authRequired)
+	{
+		me.GET("", a.MeGet)
+		me.PATCH("", a.SessionCheck, a.MePatch)
+		me.DELETE("", a.SessionCheck, a.MeDelete)
+		me.POST("/email", a.SessionCheck, a.MeEmailPost)
+		me.GET("/export", a.SessionCheck, a.MeExportListGet)
+		me.POST("/export", a.SessionCheck, a.MeExportPost)
+	}
+
+	// Finished data exports, the token in the URL is the only authentication
+	router.GET("/exports/:token", a.RateLimit("exports"), a.ExportDownloadGet)
+
+	// Personal access tokens
+	tokens := router.Group("/tokens", authRequired, a.SessionCheck)
+	{
+		tokens.GET("", a.TokenListGet)
+		tokens.POST("", a.TokenCreatePost)
+		tokens.DELETE("/:id", a.TokenDelete)
+	}
+
+	// Admin
+	//
+	// Access tokens skip the jwt middleware entirely, so they are turned away by SessionCheck
+	// before AdminCheck looks at the role jwtAuthorizator found.
+	admin := router.Group("/admin", authRequired, a.SessionCheck, a.AdminCheck)
+	{
+		admin.GET("/users", a.AdminUserListGet)
+		admin.GET("/users/:id", a.AdminUserGet)
+		admin.POST("/users/:id/deactivate", a.AdminUserDeactivatePost)
+		admin.POST("/users/:id/activate", a.AdminUserActivatePost)
+
+		admin.GET("/robots", a.AdminRobotListGet)
+		admin.DELETE("/robots/:uuid", a.AdminRobotDelete)
+		admin.POST("/robots/:uuid/decommission", a.AdminRobotDecommissionPost)
+
+		admin.GET("/audit", a.AdminAuditListGet)
+	}
+
+	// Audit
+	router.GET("/audit", authRequired, a.SessionCheck, a.AuditListGet)
+
+	// Households
+	households := router.Group("/households", authRequired, a.SessionCheck)
+	{
+		households.GET("", a.HouseholdListGet)
+		households.POST("", a.HouseholdCreatePost)
+
+		household := households.Group("/:id", a.HouseholdCheck)
+		{
+			household.GET("", a.HouseholdGet)
+			household.PATCH("", a.HouseholdOwnerCheck, a.HouseholdRenamePatch)
+			household.DELETE("", a.HouseholdOwnerCheck, a.HouseholdDelete)
+
+			household.PATCH("/members/:user_id", a.HouseholdOwnerCheck, a.HouseholdMemberPatch)
+			household.DELETE("/members/:user_id", a.HouseholdMemberDelete) // owners, or members leaving
+
+			household.GET("/invites", a.HouseholdOwnerCheck, a.HouseholdInviteListGet)
+			household.POST("/invites", a.HouseholdOwnerCheck, a.HouseholdInvitePost)
+			household.DELETE("/invites/:invite_id", a.HouseholdOwnerCheck, a.HouseholdInviteDelete)
+		}
+	}
+
+	router.POST("/invites/accept", authRequired, a.SessionCheck, a.InviteAcceptPost)
+
+	// Robot transfers
+	transfers := router.Group("/transfers", authRequired, a.SessionCheck)
+	{
+		transfers.GET("", a.TransferListGet)
+		transfers.POST("/accept", a.TransferAcceptPost)
 	}
 
 	// Log
-	logs := router.Group("/log", authRequired)
+	logs := router.Group("/log", authRequired, logScope)
 	{
 		logs.GET("", a.LogListGet)
 	}
 
 	// Robots
-	robots := router.Group("/robots", authRequired)
+	robots := router.Group("/robots", authRequired, robotsScope)
 	{
 		robots.GET("", a.RobotListGet) // List robots
 		robots.POST("/register", a.RobotRegisterPost)
+		robots.GET("/decommissions", a.DecommissionListGet)
 	}
 
 	// A robot
@@ -173,45 +283,157 @@ func NewAPI(
 		aRobot.POST("/startDemo", a.RobotStartDemoPost)
 		aRobot.PATCH("/settings", a.RobotSettingsPatch)
 		aRobot.POST("/standby", a.RobotSetStandby)
+		aRobot.PUT("/household", a.RobotHouseholdPut)
+		aRobot.POST("/transfer", a.RobotTransferPost)
+		aRobot.DELETE("/transfer", a.RobotTransferDelete)
+		aRobot.POST("/decommission", a.RobotDecommissionPost)
+		aRobot.GET("/waterings", a.RobotWateringListGet)
+		aRobot.GET("/water-usage", a.RobotWaterUsageGet)
+		aRobot.GET("/maps", a.RobotMapListGet)
+		aRobot.GET("/maps/:version", a.RobotMapGet)
+		aRobot.GET("/maps/:version/image", a.RobotMapImageGet)
+		aRobot.PUT("/maps/:version/annotations", a.RobotMapAnnotationsPut)
+		aRobot.GET("/alerts", a.RobotAlertsGet)
+		aRobot.PUT("/alerts", a.RobotAlertsPut)
+		aRobot.GET("/docking", a.RobotDockingGet)
+		aRobot.PUT("/docking", a.RobotDockingPut)
+		aRobot.POST("/dock", a.RobotDockPost)
 	}
 
 	// Photos
 	photos := router.Group("/photos", authRequired)
 	{
-		photos.GET("", a.PhotosListGet)
+		photos.GET("", plantsScope, a.PhotosListGet)
 
 		photo := photos.Group("/:id", a.PhotoCheck)
 		{
 			photo.GET("", a.PhotoServeGet)
 			photo.DELETE("", a.PhotoDelete)
+			photo.POST("/restore", a.PhotoRestorePost)
 		}
 	}
 
 	// Plants
 	plants := router.Group("/plants", authRequired)
 	{
-		plants.GET("", a.PlantListGet)
-		plants.POST("", a.PlantCreatePost) // create a plant, only {name: ""}
+		plants.GET("", plantsScope, a.PlantListGet)
+		plants.POST("", plantsScope, a.PlantCreatePost) // create a plant, only {name: ""}
 
 		plant := plants.Group("/:id", a.PlantCheck)
 		{
 			plant.GET("", a.PlantGet)
 			plant.DELETE("", a.PlantDelete)
-			plant.PATCH("", a.PlantRenamePatch)
+			plant.POST("/restore", a.PlantRestorePost)
+			plant.PATCH("", a.PlantActiveCheck, a.PlantRenamePatch)
+			plant.PUT("/household", a.PlantActiveCheck, a.PlantHouseholdPut)
+			plant.PUT("/care-profile", a.PlantActiveCheck, a.PlantCareProfilePut)
+			plant.GET("/tasks", a.PlantTaskListGet)
+			plant.GET("/waterings", a.PlantWateringListGet)
+			plant.GET("/water-usage", a.PlantWaterUsageGet)
+			plant.GET("/growth", a.PlantGrowthGet)
+			plant.PUT("/location", a.PlantActiveCheck, a.PlantLocationPut)
+			plant.PUT("/tags", a.PlantActiveCheck, a.PlantTagsPut)
+			plant.PATCH("/fields", a.PlantActiveCheck, a.PlantFieldsPatch)
+
+			plant.GET("/identifiers", a.PlantIdentifierListGet)
+			plant.POST("/identifiers", a.PlantActiveCheck, a.PlantIdentifierCreatePost)
+			plant.DELETE("/identifiers/:identifier_id", a.PlantActiveCheck, a.PlantIdentifierDelete)
+
+			plant.GET("/journal", a.PlantJournalListGet)
+			plant.POST("/journal", a.PlantActiveCheck, a.PlantJournalCreatePost)
+			plant.PUT("/journal/:entry_id", a.PlantActiveCheck, a.PlantJournalPut)
+			plant.DELETE("/journal/:entry_id", a.PlantActiveCheck, a.PlantJournalDelete)
 		}
 	}
 
+	// Printable QR codes for plants
+	router.GET("/plant-labels", authRequired, plantsScope, a.PlantLabelsGet)
+	router.POST("/plant-labels", authRequired, plantsScope, a.PlantLabelsPost)
+
+	// Zones
+	zones := router.Group("/zones", authRequired, plantsScope)
+	{
+		zones.GET("", a.ZoneListGet)
+		zones.POST("", a.ZoneCreatePost)
+
+		zone := zones.Group("/:id", a.ZoneCheck)
+		{
+			zone.GET("", a.ZoneGet)
+			zone.PATCH("", a.ZoneRenamePatch)
+			zone.DELETE("", a.ZoneDelete)
+			zone.PUT("/household", a.ZoneHouseholdPut)
+		}
+	}
+
+	// Care profiles
+	careProfiles := router.Group("/care-profiles", authRequired, plantsScope)
+	{
+		careProfiles.GET("", a.CareProfileListGet)
+		careProfiles.POST("", a.CareProfileCreatePost)
+
+		careProfile := careProfiles.Group("/:id", a.CareProfileCheck)
+		{
+			careProfile.GET("", a.CareProfileGet)
+			careProfile.PUT("", a.CareProfilePut)
+			careProfile.DELETE("", a.CareProfileDelete)
+		}
+	}
+
+	// Tasks claimed by robots
+	router.GET("/tasks", authRequired, eventsScope, a.TaskListGet)
+
+	// Watering rules
+	wateringRules := router.Group("/watering-rules", authRequired, eventsScope)
+	{
+		wateringRules.GET("", a.WateringRuleListGet)
+		wateringRules.POST("", a.WateringRuleCreatePost)
+
+		wateringRule := wateringRules.Group("/:id", a.WateringRuleCheck)
+		{
+			wateringRule.GET("", a.WateringRuleGet)
+			wateringRule.PUT("", a.WateringRulePut)
+			wateringRule.DELETE("", a.WateringRuleDelete)
+			wateringRule.GET("/firings", a.WateringRuleFiringListGet)
+		}
+	}
+
+	// Routes
+	routes := router.Group("/routes", authRequired, eventsScope)
+	{
+		routes.GET("", a.RouteListGet)
+		routes.POST("", a.RouteCreatePost)
+
+		route := routes.Group("/:id", a.RouteCheck)
+		{
+			route.GET("", a.RouteGet)
+			route.PUT("", a.RoutePut)
+			route.DELETE("", a.RouteDelete)
+			route.PUT("/household", a.RouteHouseholdPut)
+			route.POST("/run", a.RouteRunPost)
+			route.GET("/runs", a.RouteRunListGet)
+		}
+	}
+
+	// Bulk changes, kept apart from /plants/:id and /events/:id as the router can't tell them apart
+	bulk := router.Group("/bulk", authRequired)
+	{
+		bulk.POST("/plants", plantsScope, a.PlantBulkPost)
+		bulk.POST("/events", eventsScope, a.EventBulkPost)
+	}
+
 	// Events
 	events := router.Group("/events", authRequired)
 	{
-		events.GET("", a.EventListGet)
-		events.POST("", a.EventCreatePost)
+		events.GET("", eventsScope, a.EventListGet)
+		events.POST("", eventsScope, a.EventCreatePost)
 
 		event := events.Group("/:id", a.EventCheck)
 		{
 			event.GET("", a.EventGet)
 			event.PUT("", a.EventPut)
 			event.DELETE("", a.EventDelete)
+			event.POST("/restore", a.EventRestorePost)
+			event.PUT("/household", a.EventHouseholdPut)
 		}
 	}
 
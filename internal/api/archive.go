@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// archivedFilter returns the condition for the "archived" query parameter of list endpoints,
+// for the table with the given alias. By default archived rows are left out, "include" lists them too,
+// and "only" lists nothing but archived rows.
+//
+// Responds and returns false if the parameter is invalid.
+func (a *API) archivedFilter(c *gin.Context, alias string) (string, bool) {
+	switch c.Query("archived") {
+	case "":
+		return " and " + alias + ".archived_at is null", true
+	case "include":
+		return "", true
+	case "only":
+		return " and " + alias + ".archived_at is not null", true
+	}
+
+	a.error(c, http.StatusBadRequest, "archived must be one of include or only")
+	return "", false
+}
+
+// pingPlantRobots sends the robots with actions on the plant their events again
+func (a *API) pingPlantRobots(plantID int) {
+	rids := []uuid.UUID{}
+	if err := a.DB.Select(&rids, "select distinct robot_id from event_actions where plant_id = $1", plantID); err != nil {
+		a.Log.WithError(err).WithField("plant_id", plantID).Warnln("Could not get robots of plant")
+		return
+	}
+
+	for _, rid := range rids {
+		a.pingRobotEvents(rid, true)
+	}
+}
+
+// pingEventRobots sends the robots with actions in the event their events again
+func (a *API) pingEventRobots(eventID int) {
+	rids := []uuid.UUID{}
+	if err := a.DB.Select(&rids, "select distinct robot_id from event_actions where event_id = $1", eventID); err != nil {
+		a.Log.WithError(err).WithField("event_id", eventID).Warnln("Could not get robots of event")
+		return
+	}
+
+	for _, rid := range rids {
+		a.pingRobotEvents(rid, true)
+	}
+}
+
+// PlantRestorePost restores an archived plant, along with its actions.
+// Fails if the owner has since created another plant with the same name.
+func (a *API) PlantRestorePost(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	if !a.ownerCheck(c, &plant.UserID, "plant") {
+		return
+	}
+
+	if plant.ArchivedAt == nil {
+		a.error(c, http.StatusBadRequest, "Plant is not archived")
+		return
+	}
+
+	_, err := a.DB.Exec("update plants set archived_at = null where id = $1", plant.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		a.error(c, http.StatusConflict, "There is already a plant called "+plant.Name+", rename it first")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if plant.ZoneID != nil || plant.MarkerID != nil {
+		a.pingPlantMaps(plant.UserID, plant.HouseholdID)
+	}
+	a.pingPlantRobots(plant.ID)
+
+	a.audit(c, "plant.restore", "plant", strconv.Itoa(plant.ID), &plant.UserID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// EventRestorePost restores an archived event, and sends it to its robots again
+func (a *API) EventRestorePost(c *gin.Context) {
+	event := c.MustGet("event").(*models.Event)
+
+	if !a.ownerCheck(c, &event.UserID, "event") {
+		return
+	}
+
+	if event.ArchivedAt == nil {
+		a.error(c, http.StatusBadRequest, "Event is not archived")
+		return
+	}
+
+	if _, err := a.DB.Exec("update events set archived_at = null where id = $1", event.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.pingEventRobots(event.ID)
+
+	a.audit(c, "event.restore", "event", strconv.Itoa(event.ID), &event.UserID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// PhotoRestorePost restores an archived photo
+func (a *API) PhotoRestorePost(c *gin.Context) {
+	photo := c.MustGet("photo").(*models.PlantPhoto)
+
+	ownerID := c.GetInt("photo_owner_id")
+	if !a.ownerCheck(c, &ownerID, "photo") {
+		return
+	}
+
+	if photo.ArchivedAt == nil {
+		a.error(c, http.StatusBadRequest, "Photo is not archived")
+		return
+	}
+
+	if _, err := a.DB.Exec("update plant_photos set archived_at = null where id = $1", photo.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// purgeArchived periodically deletes plants, events and photos that were archived longer ago
+// than the retention period, along with the files of the photos
+func (a *API) purgeArchived() {
+	for range time.Tick(time.Hour) {
+		if err := a.purgeArchivedBefore(time.Now().UTC().Add(-a.Config.ArchiveRetention)); err != nil {
+			a.Log.WithError(err).Warnln("Could not purge archived rows")
+		}
+	}
+}
+
+func (a *API) purgeArchivedBefore(cutoff time.Time) error {
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Photos go with their plant, so the files of plants being purged have to be deleted too
+	filenames := []uuid.UUID{}
+	err = tx.Select(
+		&filenames,
+		"delete from plant_photos where archived_at < $1 or plant_id in (select id from plants where archived_at < $1) returning filename",
+		cutoff,
+	)
+	if err != nil {
+		return err
+	}
+
+	plants, err := tx.Exec("delete from plants where archived_at < $1", cutoff)
+	if err != nil {
+		return err
+	}
+
+	events, err := tx.Exec("delete from events where archived_at < $1", cutoff)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		if err := a.Bucket.Delete(context.Background(), photoBucketKey(filename)); err != nil {
+			a.Log.WithError(err).WithField("filename", filename).Warnln("Could not delete purged photo")
+		}
+	}
+
+	plantCount, _ := plants.RowsAffected()
+	eventCount, _ := events.RowsAffected()
+	if len(filenames) > 0 || plantCount > 0 || eventCount > 0 {
+		a.Log.WithField("photos", len(filenames)).WithField("plants", plantCount).WithField("events", eventCount).Infoln("Purged archived rows")
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// How the actor of an audited action proved who they were
+const (
+	AuditCredentialNone        = "none"
+	AuditCredentialPassword    = "password"
+	AuditCredentialOIDC        = "oidc"
+	AuditCredentialSession     = "session"
+	AuditCredentialAccessToken = "access_token"
+)
+
+// AuditEntry is a row of the append-only audit log.
+//
+// Payloads are never stored, only a digest of them, so that the log can
+// confirm what was sent without holding on to it.
+type AuditEntry struct {
+	ID            int       `json:"id" db:"id"`
+	ActorID       *int      `json:"actor_id" db:"actor_id"`
+	Credential    string    `json:"credential" db:"credential"`
+	TokenID       *int      `json:"token_id,omitempty" db:"token_id"`
+	IP            *string   `json:"ip" db:"ip"`
+	Action        string    `json:"action" db:"action"`
+	TargetType    *string   `json:"target_type,omitempty" db:"target_type"`
+	TargetID      *string   `json:"target_id,omitempty" db:"target_id"`
+	OwnerID       *int      `json:"owner_id,omitempty" db:"owner_id"`
+	PayloadDigest *string   `json:"payload_digest,omitempty" db:"payload_digest"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// payloadDigest returns the sha256 of the JSON encoding of data, or nil if there is no data
+func payloadDigest(data interface{}) *string {
+	if data == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+
+	sum := sha256.Sum256(b)
+	digest := hex.EncodeToString(sum[:])
+	return &digest
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// insertAudit writes an entry to the audit log. Failing to do so is logged, but doesn't stop the action.
+func (a *API) insertAudit(entry AuditEntry) {
+	_, err := a.DB.NamedExec(
+		"insert into audit_log(actor_id, credential, token_id, ip, action, target_type, target_id, owner_id, payload_digest) values (:actor_id, :credential, :token_id, :ip, :action, :target_type, :target_id, :owner_id, :payload_digest)",
+		entry,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("action", entry.Action).WithField("actor_id", entry.ActorID).Warnln("Could not write audit log")
+	}
+}
+
+// audit records an action taken by whoever made the request.
+//
+// ownerID is the user whose resource was acted upon, so that it shows up in their audit log. data can be nil.
+func (a *API) audit(c *gin.Context, action string, targetType string, targetID string, ownerID *int, data interface{}) {
+	entry := AuditEntry{
+		Credential:    AuditCredentialNone,
+		IP:            optionalString(c.ClientIP()),
+		Action:        action,
+		TargetType:    optionalString(targetType),
+		TargetID:      optionalString(targetID),
+		OwnerID:       ownerID,
+		PayloadDigest: payloadDigest(data),
+	}
+
+	if _, ok := c.Get("user_id"); ok {
+		uid := c.GetInt("user_id")
+		entry.ActorID = &uid
+		entry.Credential = AuditCredentialSession
+	}
+
+	if v, ok := c.Get("access_token"); ok {
+		token := v.(*models.AccessToken)
+		entry.Credential = AuditCredentialAccessToken
+		entry.TokenID = &token.ID
+	}
+
+	a.insertAudit(entry)
+}
+
+// auditQuery builds the where clause shared by the audit log listings, from the query string
+func auditQuery(c *gin.Context, query string, args []interface{}) (string, []interface{}, error) {
+	input := struct {
+		Action     *string `form:"action"`
+		TargetType *string `form:"target_type"`
+		TargetID   *string `form:"target_id"`
+		ActorID    *int    `form:"actor_id"`
+		OwnerID    *int    `form:"owner_id"`
+		IP         *string `form:"ip"`
+		Limit      int     `form:"limit,default=50"`
+		Offset     int     `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		return "", nil, err
+	}
+
+	filters := []struct {
+		column string
+		value  interface{}
+		set    bool
+	}{
+		{"action", input.Action, input.Action != nil},
+		{"target_type", input.TargetType, input.TargetType != nil},
+		{"target_id", input.TargetID, input.TargetID != nil},
+		{"actor_id", input.ActorID, input.ActorID != nil},
+		{"owner_id", input.OwnerID, input.OwnerID != nil},
+		{"ip", input.IP, input.IP != nil},
+	}
+
+	for _, filter := range filters {
+		if !filter.set {
+			continue
+		}
+		args = append(args, filter.value)
+		query += fmt.Sprintf(" and %s=$%d", filter.column, len(args))
+	}
+
+	args = append(args, input.Limit, input.Offset)
+	query += fmt.Sprintf(" order by created_at desc limit $%d offset $%d", len(args)-1, len(args))
+
+	return query, args, nil
+}
+
+// AuditListGet lists the audit log entries about the current user's resources, or actions they took
+func (a *API) AuditListGet(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	query, args, err := auditQuery(c, "(actor_id=$1 or owner_id=$1)", []interface{}{userID})
+	if err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries := []AuditEntry{}
+	if err := a.DB.Select(&entries, "select * from audit_log where "+query, args...); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+// AdminAuditListGet lists all audit log entries
+func (a *API) AdminAuditListGet(c *gin.Context) {
+	query, args, err := auditQuery(c, "true", []interface{}{})
+	if err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries := []AuditEntry{}
+	if err := a.DB.Select(&entries, "select * from audit_log where "+query, args...); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "admin.audit.list", "", "", nil, c.Request.URL.Query())
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"net/mail"
+	"strconv"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -17,6 +19,47 @@ func validatePassword(pw string) (bool, string) {
 	return true, ""
 }
 
+func validateEmail(email string) (bool, string) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false, "Invalid email address"
+	}
+
+	return true, ""
+}
+
+// checkPassword confirms the password of the user, responding and returning false if it's wrong.
+//
+// Failures count towards the same lockout as failed logins to the account.
+func (a *API) checkPassword(c *gin.Context, userID int, password string) bool {
+	var user models.User
+	err := a.DB.Get(&user, "select email, password from users where id = $1", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	accountKey := accountLimitKey(user.Email)
+	if wait := a.limiter.locked(accountKey); wait > 0 {
+		a.tooManyRequests(c, wait)
+		return false
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		if wait := a.limiter.fail(accountKey); wait > 0 {
+			a.tooManyRequests(c, wait)
+			return false
+		}
+
+		BadRequest(c, "Incorrect password")
+		return false
+	}
+
+	a.limiter.succeed(accountKey)
+	return true
+}
+
 // AuthRegisterPost takes:
 // - forename
 // - surname
@@ -47,7 +90,13 @@ func (a *API) AuthRegisterPost(c *gin.Context) {
 		return
 	}
 
-	success, errMsg := validatePassword(input.Password)
+	success, errMsg := validateEmail(input.Email)
+	if !success {
+		BadRequest(c, errMsg)
+		return
+	}
+
+	success, errMsg = validatePassword(input.Password)
 	if !success {
 		BadRequest(c, errMsg)
 		return
@@ -122,17 +171,7 @@ func (a *API) AuthChgPassPost(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-
-	err = a.DB.Get(&user, "select id,password from users where id = $1 limit 1", userID)
-	if err != nil {
-		BadRequest(c, err.Error())
-		return
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Old))
-	if err != nil {
-		BadRequest(c, err.Error())
+	if !a.checkPassword(c, userID, input.Old) {
 		return
 	}
 
@@ -151,6 +190,8 @@ func (a *API) AuthChgPassPost(c *gin.Context) {
 		return
 	}
 
+	a.audit(c, "auth.password.change", "user", strconv.Itoa(userID), &userID, nil)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Successfully updated password!",
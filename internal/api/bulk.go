@@ -0,0 +1,419 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// BulkMaxOperations is the most operations a single bulk request can have
+const BulkMaxOperations = 500
+
+// How bulk requests deal with operations that fail
+const (
+	// BulkModeAtomic changes nothing if any operation fails
+	BulkModeAtomic = "atomic"
+
+	// BulkModePartial applies the operations that succeed, and skips the ones that fail
+	BulkModePartial = "partial"
+)
+
+// What a bulk operation does
+const (
+	BulkOpCreate = "create"
+	BulkOpUpdate = "update"
+	BulkOpDelete = "delete"
+)
+
+// bulkResult is the outcome of a single operation of a bulk request
+type bulkResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     int    `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkApply carries out the i'th operation in the transaction, returning the id of the row it affected.
+// Returns a message if the operation is invalid.
+type bulkApply func(tx *sqlx.Tx, i int) (int, string, error)
+
+// runBulk carries out the operations in one transaction, each in its own savepoint so that
+// a failing operation can be undone on its own. Every operation is tried, so that the results
+// say what is wrong with all of them.
+//
+// Responds with the results, and returns whether the transaction was committed.
+func (a *API) runBulk(c *gin.Context, mode string, ops []string, apply bulkApply) bool {
+	if mode == "" {
+		mode = BulkModeAtomic
+	} else if mode != BulkModeAtomic && mode != BulkModePartial {
+		a.error(c, http.StatusBadRequest, "mode must be one of atomic or partial")
+		return false
+	}
+
+	if len(ops) == 0 || len(ops) > BulkMaxOperations {
+		a.error(c, http.StatusBadRequest, "Bulk requests must have between 1 and "+strconv.Itoa(BulkMaxOperations)+" operations")
+		return false
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	defer tx.Rollback()
+
+	results := make([]bulkResult, len(ops))
+	failed := 0
+
+	for i, op := range ops {
+		results[i] = bulkResult{Index: i, Op: op, Status: "success"}
+
+		if _, err := tx.Exec("savepoint bulk_operation"); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return false
+		}
+
+		id, msg, err := apply(tx, i)
+		if err != nil {
+			msg = err.Error()
+		}
+
+		if msg != "" {
+			if _, err := tx.Exec("rollback to savepoint bulk_operation"); err != nil {
+				a.error(c, http.StatusInternalServerError, err.Error())
+				return false
+			}
+
+			results[i].Status = "error"
+			results[i].Error = msg
+			failed++
+			continue
+		}
+
+		if _, err := tx.Exec("release savepoint bulk_operation"); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return false
+		}
+		results[i].ID = id
+	}
+
+	if failed > 0 && mode == BulkModeAtomic {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("%d of %d operations failed, so nothing was changed", failed, len(ops)),
+			"results": results,
+		})
+		return false
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"failed":  failed,
+		"results": results,
+	})
+	return true
+}
+
+// PlantBulkPost creates, updates and deletes many plants at once. Takes the "mode" (atomic, the default, or partial)
+// and a list of "operations", each with an "op":
+//   - "create" takes a "name", and optionally a "care_profile_id" and "tags"
+//   - "update" takes the "id", and any of the "name", "care_profile_id" and "tags" to change
+//   - "delete" takes the "id", and archives the plant
+//
+// Responds with the result of each operation, in order.
+func (a *API) PlantBulkPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Mode       string `json:"mode"`
+		Operations []struct {
+			Op            string   `json:"op"`
+			ID            int      `json:"id"`
+			Name          *string  `json:"name"`
+			CareProfileID *int     `json:"care_profile_id"`
+			Tags          []string `json:"tags"`
+		} `json:"operations"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ops := make([]string, len(input.Operations))
+	for i, op := range input.Operations {
+		ops[i] = op.Op
+	}
+
+	// Done once the changes have been committed
+	archived := []models.Plant{}
+	changedProfiles := []int{}
+
+	apply := func(tx *sqlx.Tx, i int) (int, string, error) {
+		op := input.Operations[i]
+
+		var tags interface{}
+		if op.Tags != nil {
+			normalised, msg := validateTags(op.Tags)
+			if msg != "" {
+				return 0, msg, nil
+			}
+			tags = pq.Array(normalised)
+		}
+
+		switch op.Op {
+		case BulkOpCreate:
+			if op.Name == nil || *op.Name == "" {
+				return 0, "Plants must have a name", nil
+			}
+
+			if op.CareProfileID != nil {
+				if _, err := a.careProfileFor(*op.CareProfileID, userID); err != nil {
+					return 0, "Care profile does not exist", nil
+				}
+			}
+
+			var id int
+			err := tx.Get(
+				&id,
+				"insert into plants(name, user_id, care_profile_id, tags) values ($1, $2, $3, coalesce($4, '{}'::text[])) returning id",
+				*op.Name, userID, op.CareProfileID, tags,
+			)
+			return id, "", err
+
+		case BulkOpUpdate:
+			plant := models.Plant{}
+			err := tx.Get(&plant, "select * from plants where id = $1 and archived_at is null and "+sqlCanChange, op.ID, userID)
+			if err == sql.ErrNoRows {
+				return 0, "Plant does not exist", nil
+			} else if err != nil {
+				return 0, "", err
+			}
+
+			if op.Name != nil && *op.Name == "" {
+				return 0, "Plants must have a name", nil
+			}
+
+			if op.CareProfileID != nil {
+				if _, err := a.careProfileFor(*op.CareProfileID, plant.UserID); err != nil {
+					return 0, "Care profile does not exist", nil
+				}
+				changedProfiles = append(changedProfiles, plant.ID)
+			}
+
+			_, err = tx.Exec(
+				"update plants set name = coalesce($2, name), care_profile_id = coalesce($3, care_profile_id), tags = coalesce($4, tags) where id = $1",
+				plant.ID, op.Name, op.CareProfileID, tags,
+			)
+			return plant.ID, "", err
+
+		case BulkOpDelete:
+			// Only the owner can delete a plant
+			plant := models.Plant{}
+			err := tx.Get(&plant, "update plants set archived_at = timezone('utc', now()) where id = $1 and user_id = $2 and archived_at is null returning *", op.ID, userID)
+			if err == sql.ErrNoRows {
+				return 0, "Plant does not exist, or isn't yours", nil
+			} else if err != nil {
+				return 0, "", err
+			}
+
+			archived = append(archived, plant)
+			return plant.ID, "", nil
+		}
+
+		return 0, "op must be one of create, update or delete", nil
+	}
+
+	if !a.runBulk(c, input.Mode, ops, apply) {
+		return
+	}
+
+	for _, plant := range archived {
+		if plant.ZoneID != nil || plant.MarkerID != nil {
+			a.pingPlantMaps(plant.UserID, plant.HouseholdID)
+		}
+		a.pingPlantRobots(plant.ID)
+	}
+
+	go func() {
+		for _, id := range changedProfiles {
+			a.updatePlantHealth(id)
+		}
+	}()
+}
+
+// EventBulkPost creates, updates and deletes many events at once. Takes the "mode" (atomic, the default, or partial)
+// and a list of "operations", each with an "op":
+//   - "create" takes the same as EventCreatePost
+//   - "update" takes the "id", and any of the "summary", "recurrences" and "actions" to replace
+//   - "delete" takes the "id", and archives the event
+//
+// Responds with the result of each operation, in order.
+func (a *API) EventBulkPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Mode       string `json:"mode"`
+		Operations []struct {
+			Op          string               `json:"op"`
+			ID          int                  `json:"id"`
+			Summary     *string              `json:"summary"`
+			Recurrences []string             `json:"recurrences"`
+			Ephemeral   bool                 `json:"ephemeral"`
+			HouseholdID *int                 `json:"household_id"`
+			Actions     []models.EventAction `json:"actions"`
+		} `json:"operations"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ops := make([]string, len(input.Operations))
+	for i, op := range input.Operations {
+		ops[i] = op.Op
+	}
+
+	// Robots that have to be sent their events again, once the changes have been committed
+	rids := map[uuid.UUID]struct{}{}
+	archived := []models.Event{}
+
+	insertActions := func(tx *sqlx.Tx, eventID int, actions []models.EventAction) error {
+		for _, action := range actions {
+			_, err := tx.Exec(
+				"insert into event_actions(event_id, name, plant_id, robot_id, data) values ($1, $2, $3, $4, $5)",
+				eventID, action.Name, action.PlantID, action.RobotID, action.Data,
+			)
+			if err != nil {
+				return err
+			}
+			rids[action.RobotID] = struct{}{}
+		}
+		return nil
+	}
+
+	apply := func(tx *sqlx.Tx, i int) (int, string, error) {
+		op := input.Operations[i]
+
+		if op.Actions != nil {
+			if msg, err := a.validateEventActions(userID, op.Actions); err != nil || msg != "" {
+				return 0, msg, err
+			}
+		}
+
+		switch op.Op {
+		case BulkOpCreate:
+			if hid := op.HouseholdID; hid != nil {
+				role, err := a.householdRole(userID, *hid)
+				if err != nil {
+					return 0, "", err
+				} else if role == "" || role == models.HouseholdRoleViewer {
+					return 0, "You can't share things with that household", nil
+				}
+			}
+
+			summary := ""
+			if op.Summary != nil {
+				summary = *op.Summary
+			}
+
+			var id int
+			err := tx.Get(
+				&id,
+				"insert into events (summary, recurrence, user_id, ephemeral, household_id) values ($1, $2, $3, $4, $5) returning id",
+				summary, pq.StringArray(op.Recurrences), userID, op.Ephemeral, op.HouseholdID,
+			)
+			if err != nil {
+				return 0, "", err
+			}
+
+			return id, "", insertActions(tx, id, op.Actions)
+
+		case BulkOpUpdate:
+			event := models.Event{}
+			err := tx.Get(&event, "select * from events where id = $1 and archived_at is null and "+sqlCanChange, op.ID, userID)
+			if err == sql.ErrNoRows {
+				return 0, "Event does not exist", nil
+			} else if err != nil {
+				return 0, "", err
+			}
+
+			var recurrences interface{}
+			if op.Recurrences != nil {
+				recurrences = pq.StringArray(op.Recurrences)
+			}
+
+			_, err = tx.Exec("update events set summary = coalesce($2, summary), recurrence = coalesce($3, recurrence) where id = $1", event.ID, op.Summary, recurrences)
+			if err != nil {
+				return 0, "", err
+			}
+
+			// Robots with the old actions get their events again too
+			old := []uuid.UUID{}
+			if err := tx.Select(&old, "select robot_id from event_actions where event_id = $1", event.ID); err != nil {
+				return 0, "", err
+			}
+			for _, rid := range old {
+				rids[rid] = struct{}{}
+			}
+
+			if op.Actions == nil {
+				return event.ID, "", nil
+			}
+
+			if _, err := tx.Exec("delete from event_actions where event_id = $1", event.ID); err != nil {
+				return 0, "", err
+			}
+			return event.ID, "", insertActions(tx, event.ID, op.Actions)
+
+		case BulkOpDelete:
+			// Only the owner can delete an event
+			event := models.Event{}
+			err := tx.Get(&event, "update events set archived_at = timezone('utc', now()) where id = $1 and user_id = $2 and archived_at is null returning *", op.ID, userID)
+			if err == sql.ErrNoRows {
+				return 0, "Event does not exist, or isn't yours", nil
+			} else if err != nil {
+				return 0, "", err
+			}
+
+			old := []uuid.UUID{}
+			if err := tx.Select(&old, "select robot_id from event_actions where event_id = $1", event.ID); err != nil {
+				return 0, "", err
+			}
+			for _, rid := range old {
+				rids[rid] = struct{}{}
+			}
+
+			archived = append(archived, event)
+			return event.ID, "", nil
+		}
+
+		return 0, "op must be one of create, update or delete", nil
+	}
+
+	if !a.runBulk(c, input.Mode, ops, apply) {
+		return
+	}
+
+	for _, event := range archived {
+		a.audit(c, "event.delete", "event", strconv.Itoa(event.ID), &event.UserID, nil)
+	}
+
+	for rid := range rids {
+		a.pingRobotEvents(rid, false)
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// careProfileInput is what users can set on their own care profiles
+type careProfileInput struct {
+	Name          string  `json:"name"`
+	Species       *string `json:"species"`
+	MinMoisture   int     `json:"min_moisture"`
+	MaxMoisture   int     `json:"max_moisture"`
+	WaterVolume   int     `json:"water_volume"`
+	Light         string  `json:"light"`
+	PhotoInterval int     `json:"photo_interval"`
+}
+
+func (input *careProfileInput) validate() (bool, string) {
+	if input.Name == "" {
+		return false, "Care profiles must have a name"
+	} else if input.MinMoisture < 0 || input.MaxMoisture > 100 || input.MinMoisture > input.MaxMoisture {
+		return false, "The moisture range must be within 0 to 100, with min_moisture no more than max_moisture"
+	} else if input.WaterVolume <= 0 {
+		return false, "water_volume must be positive"
+	} else if !models.ValidLight(input.Light) {
+		return false, "Unknown light level " + input.Light
+	} else if input.PhotoInterval <= 0 {
+		return false, "photo_interval must be positive"
+	}
+	return true, ""
+}
+
+// careProfileFor gets a care profile that can be given to a plant owned by userID:
+// one from the catalog, or one of their own. Returns sql.ErrNoRows otherwise.
+func (a *API) careProfileFor(profileID int, userID int) (*models.CareProfile, error) {
+	var profile models.CareProfile
+	err := a.DB.Get(&profile, "select * from care_profiles where id = $1 and (user_id is null or user_id = $2)", profileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// CareProfileCheck is a middleware to check whether the passed care profile exists,
+// and that it is either part of the catalog or belongs to the currently logged in user
+func (a *API) CareProfileCheck(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		c.Abort()
+		return
+	}
+
+	profile, err := a.careProfileFor(id, c.GetInt("user_id"))
+	if err != nil {
+		BadRequest(c, "Care profile does not exist ("+err.Error()+")")
+		c.Abort()
+		return
+	}
+
+	c.Set("care_profile", profile)
+}
+
+// CareProfileListGet lists the catalog, along with the current user's own profiles.
+// Takes an optional "q" to search names and species.
+func (a *API) CareProfileListGet(c *gin.Context) {
+	profiles := []models.CareProfile{}
+
+	err := a.DB.Select(
+		&profiles,
+		"select * from care_profiles where (user_id is null or user_id = $1) and ($2 = '' or name ilike '%' || $2 || '%' or species ilike '%' || $2 || '%') order by user_id nulls first, name",
+		c.GetInt("user_id"), c.Query("q"),
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles,
+	})
+}
+
+// CareProfileCreatePost creates a care profile belonging to the current user
+func (a *API) CareProfileCreatePost(c *gin.Context) {
+	var input careProfileInput
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if ok, msg := input.validate(); !ok {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	var id int
+	err := a.DB.Get(
+		&id,
+		"insert into care_profiles(user_id, name, species, min_moisture, max_moisture, water_volume, light, photo_interval) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id",
+		c.GetInt("user_id"), input.Name, input.Species, input.MinMoisture, input.MaxMoisture, input.WaterVolume, input.Light, input.PhotoInterval,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// CareProfileGet gets the care profile
+func (a *API) CareProfileGet(c *gin.Context) {
+	profile := c.MustGet("care_profile").(*models.CareProfile)
+	c.JSON(http.StatusOK, profile)
+}
+
+// CareProfilePut replaces one of the current user's own care profiles. The catalog can't be changed.
+func (a *API) CareProfilePut(c *gin.Context) {
+	profile := c.MustGet("care_profile").(*models.CareProfile)
+
+	if profile.UserID == nil {
+		a.error(c, http.StatusForbidden, "Care profiles from the catalog can't be changed, create your own instead")
+		return
+	}
+
+	var input careProfileInput
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if ok, msg := input.validate(); !ok {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	_, err := a.DB.Exec(
+		"update care_profiles set name = $2, species = $3, min_moisture = $4, max_moisture = $5, water_volume = $6, light = $7, photo_interval = $8 where id = $1",
+		profile.ID, input.Name, input.Species, input.MinMoisture, input.MaxMoisture, input.WaterVolume, input.Light, input.PhotoInterval,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Plants using the profile are judged against its new moisture range
+	plantIDs := []int{}
+	if err := a.DB.Select(&plantIDs, "select id from plants where care_profile_id = $1", profile.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	go func() {
+		for _, id := range plantIDs {
+			a.updatePlantHealth(id)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// CareProfileDelete deletes one of the current user's own care profiles. Plants using it are left without one.
+func (a *API) CareProfileDelete(c *gin.Context) {
+	profile := c.MustGet("care_profile").(*models.CareProfile)
+
+	if profile.UserID == nil {
+		a.error(c, http.StatusForbidden, "Care profiles from the catalog can't be deleted")
+		return
+	}
+
+	if _, err := a.DB.Exec("delete from care_profiles where id = $1", profile.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// PlantCareProfilePut links the plant to a care profile. Takes a "care_profile_id", which can be null.
+//
+// The profile has to be from the catalog, or belong to the owner of the plant.
+func (a *API) PlantCareProfilePut(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		CareProfileID *int `json:"care_profile_id"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.CareProfileID != nil {
+		_, err := a.careProfileFor(*input.CareProfileID, plant.UserID)
+		if err == sql.ErrNoRows {
+			a.error(c, http.StatusBadRequest, "Care profile does not exist")
+			return
+		} else if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	_, err := a.DB.Exec("update plants set care_profile_id = $2 where id = $1", plant.ID, input.CareProfileID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The moisture range may have changed
+	a.updatePlantHealth(plant.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
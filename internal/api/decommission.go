@@ -0,0 +1,192 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// RobotDecommission is a factory reset of a robot, waiting to be acknowledged by the robot
+type RobotDecommission struct {
+	RobotID     uuid.UUID  `json:"robot_id" db:"robot_id"`
+	RequestedBy *int       `json:"requested_by" db:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at" db:"requested_at"`
+	SentAt      *time.Time `json:"sent_at" db:"sent_at"`
+	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+}
+
+// decommissionRobot wipes everything we hold about the robot, and tells it to factory reset.
+//
+// Its events (and its actions in events shared with other robots), log entries, transfers, maps,
+// watering rules, task leases, route runs and state are removed, it is unregistered, and its admin token is replaced.
+func (a *API) decommissionRobot(rid uuid.UUID, requestedBy int) error {
+	adminToken, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	maps := []uuid.UUID{}
+	if err := tx.Select(&maps, "select filename from robot_maps where robot_id = $1", rid); err != nil {
+		return err
+	}
+
+	queries := []string{
+		"delete from events where id in (select event_id from event_actions where robot_id = $1) and not exists (select 1 from event_actions as a where a.event_id = events.id and a.robot_id != $1)",
+		"delete from event_actions where robot_id = $1",
+		"delete from log where robot_id = $1",
+		"delete from robot_transfers where robot_id = $1",
+		"delete from robot_alerts where robot_id = $1",
+		"delete from robot_docking where robot_id = $1",
+		"delete from robot_maps where robot_id = $1",
+		"delete from watering_rules where robot_id = $1",
+		"delete from watering_rule_firings where robot_id = $1",
+		"delete from task_leases where robot_id = $1",
+		"delete from route_runs where robot_id = $1",
+		"update robot_state set battery_level = default, water_level = default, standby = default, tank_capacity = default, water_level_at = null, battery_level_at = null, docked = default, charging = default, docked_at = null, seen_at = null where id = $1",
+	}
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query, rid); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("update robots set user_id = null, title = '', household_id = null, admin_token = $2, updated_at = timezone('utc', now()) where id = $1", rid, adminToken)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`insert into robot_decommissions(robot_id, requested_by) values ($1, $2)
+		on conflict (robot_id) do update set requested_by = $2, requested_at = timezone('utc', now()), sent_at = null, completed_at = null`,
+		rid, requestedBy,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.deleteMapFiles(maps)
+	a.sendFactoryReset(rid)
+	return nil
+}
+
+// sendFactoryReset sends FACTORY_RESET to the robot if there is a reset waiting for it.
+// If the robot isn't connected, this happens once it connects.
+func (a *API) sendFactoryReset(rid uuid.UUID) {
+	var pending bool
+	err := a.DB.Get(&pending, "select exists(select 1 from robot_decommissions where robot_id = $1 and completed_at is null)", rid)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not check for pending factory reset")
+		return
+	} else if !pending {
+		return
+	}
+
+	payload := struct {
+		Type string `json:"type"`
+	}{"FACTORY_RESET"}
+
+	sent, err := writeRobot(rid, payload)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not send FACTORY_RESET")
+		return
+	} else if !sent {
+		return
+	}
+
+	if _, err := a.DB.Exec("update robot_decommissions set sent_at = timezone('utc', now()) where robot_id = $1", rid); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not mark FACTORY_RESET as sent")
+	}
+}
+
+// streamRobotFactoryResetComplete is sent by the robot once it has reset itself
+func (a *API) streamRobotFactoryResetComplete(rid uuid.UUID) {
+	var decommission RobotDecommission
+	err := a.DB.Get(&decommission, "update robot_decommissions set completed_at = timezone('utc', now()) where robot_id = $1 and completed_at is null returning *", rid)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Received FACTORY_RESET_COMPLETE without a pending reset")
+		return
+	}
+
+	a.Log.WithField("rid", rid).Infoln("Robot has been factory reset")
+
+	if decommission.RequestedBy != nil {
+		a.userStreams.transmit(*decommission.RequestedBy, "ROBOT_DECOMMISSIONED", decommission)
+	}
+}
+
+// RobotDecommissionPost wipes the robot and tells it to factory reset.
+// Unlike RobotDelete, the robot's history is removed too, and the robot itself is reset.
+func (a *API) RobotDecommissionPost(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	if !a.ownerCheck(c, robot.UserID, "robot") {
+		return
+	}
+
+	if err := a.decommissionRobot(robot.ID, c.GetInt("user_id")); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "robot.decommission", "robot", robot.ID.String(), robot.UserID, nil)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "The robot will be reset as soon as it is online",
+	})
+}
+
+// DecommissionListGet lists the factory resets the current user has asked for
+func (a *API) DecommissionListGet(c *gin.Context) {
+	decommissions := []RobotDecommission{}
+
+	err := a.DB.Select(&decommissions, "select * from robot_decommissions where requested_by = $1 order by requested_at desc", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"decommissions": decommissions,
+	})
+}
+
+// AdminRobotDecommissionPost decommissions any robot
+func (a *API) AdminRobotDecommissionPost(c *gin.Context) {
+	rid, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	var previousOwner *int
+	if err := a.DB.Get(&previousOwner, "select user_id from robots where id = $1", rid); err != nil {
+		a.error(c, http.StatusNotFound, "Robot does not exist ("+err.Error()+")")
+		return
+	}
+
+	if err := a.decommissionRobot(rid, c.GetInt("user_id")); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "admin.robots.decommission", "robot", rid.String(), previousOwner, nil)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// DockCheckInterval is how often robots are checked for being away from their dock when they shouldn't be
+const DockCheckInterval = time.Minute
+
+var errQuietHours = errors.New("the robot is in its quiet hours")
+var errLowBattery = errors.New("the robot's battery is too low")
+
+// robotDocking gets the quiet hours and minimum battery of the robot, creating the defaults if it has none yet
+func (a *API) robotDocking(rid uuid.UUID) (*models.RobotDocking, error) {
+	if _, err := a.DB.Exec("insert into robot_docking(robot_id) values ($1) on conflict (robot_id) do nothing", rid); err != nil {
+		return nil, err
+	}
+
+	var docking models.RobotDocking
+	if err := a.DB.Get(&docking, "select * from robot_docking where robot_id = $1", rid); err != nil {
+		return nil, err
+	}
+	return &docking, nil
+}
+
+// canDispatch returns errQuietHours or errLowBattery if the robot shouldn't be sent to do anything right now.
+// Robots that have never reported their battery level are assumed to have enough.
+func (a *API) canDispatch(rid uuid.UUID) error {
+	docking, err := a.robotDocking(rid)
+	if err != nil {
+		return err
+	}
+
+	if quiet, err := docking.InQuietHours(time.Now()); err != nil {
+		return err
+	} else if quiet {
+		return errQuietHours
+	}
+
+	var state models.RobotState
+	if err := a.DB.Get(&state, "select battery_level, battery_level_at from robot_state where id = $1", rid); err != nil {
+		return err
+	} else if state.BatteryLevelAt != nil && state.BatteryLevel < docking.MinBattery {
+		return errLowBattery
+	}
+
+	return nil
+}
+
+// dispatchReason returns the reason robots are given for a canDispatch error, or "" if it's some other error
+func dispatchReason(err error) string {
+	switch err {
+	case errQuietHours:
+		return "quiet_hours"
+	case errLowBattery:
+		return "low_battery"
+	}
+	return ""
+}
+
+// sendDockingSchedule lets the robot know its quiet hours and minimum battery, so that it can keep to them by itself
+func (a *API) sendDockingSchedule(rid uuid.UUID) {
+	docking, err := a.robotDocking(rid)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not get docking schedule")
+		return
+	}
+
+	a.sendRobotMessage(rid, "DOCKING_SCHEDULE", docking)
+}
+
+// enforceDocking sends robots that are out during their quiet hours, or with too little battery, back to their dock
+func (a *API) enforceDocking() {
+	for range time.Tick(DockCheckInterval) {
+		robotCtxsMutex.Lock()
+		rids := make([]string, 0, len(robotCtxs))
+		for rid := range robotCtxs {
+			rids = append(rids, rid.String())
+		}
+		robotCtxsMutex.Unlock()
+
+		if len(rids) == 0 {
+			continue
+		}
+
+		robots := []struct {
+			models.RobotDocking
+			BatteryLevel   int        `db:"battery_level"`
+			BatteryLevelAt *time.Time `db:"battery_level_at"`
+			Charging       bool       `db:"charging"`
+		}{}
+
+		err := a.DB.Select(
+			&robots,
+			"select d.*, s.battery_level, s.battery_level_at, s.charging from robot_docking as d, robot_state as s where s.id = d.robot_id and not s.docked and d.robot_id = any($1)",
+			pq.Array(rids),
+		)
+		if err != nil {
+			a.Log.WithError(err).Warnln("Could not get robots to dock")
+			continue
+		}
+
+		for _, robot := range robots {
+			reason := ""
+			if quiet, err := robot.InQuietHours(time.Now()); err != nil {
+				a.Log.WithError(err).WithField("rid", robot.RobotID).Warnln("Could not check quiet hours")
+				continue
+			} else if quiet {
+				reason = dispatchReason(errQuietHours)
+			} else if robot.BatteryLevelAt != nil && robot.BatteryLevel < robot.MinBattery && !robot.Charging {
+				reason = dispatchReason(errLowBattery)
+			}
+
+			if reason != "" {
+				a.sendRobotMessage(robot.RobotID, "DOCK", gin.H{"reason": reason})
+			}
+		}
+	}
+}
+
+// RobotDockingGet returns the robot's quiet hours and minimum battery
+func (a *API) RobotDockingGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	docking, err := a.robotDocking(robot.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, docking)
+}
+
+// RobotDockingPut sets the robot's quiet hours and minimum battery. Takes a "quiet_start" and "quiet_end"
+// (as "hh:mm", or both null for no quiet hours), the "timezone" they are in, and the "min_battery" in percent.
+//
+// Nothing is sent to the robot during its quiet hours, or while its battery is below the minimum.
+func (a *API) RobotDockingPut(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	input := models.RobotDocking{}
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Timezone == "" {
+		input.Timezone = "UTC"
+	}
+
+	if _, err := time.LoadLocation(input.Timezone); err != nil {
+		a.error(c, http.StatusBadRequest, "Unknown timezone "+input.Timezone)
+		return
+	} else if input.MinBattery < 0 || input.MinBattery > 100 {
+		a.error(c, http.StatusBadRequest, "min_battery must be between 0 and 100")
+		return
+	} else if (input.QuietStart == nil) != (input.QuietEnd == nil) {
+		a.error(c, http.StatusBadRequest, "quiet_start and quiet_end must both be set, or both be null")
+		return
+	}
+
+	if input.QuietStart != nil {
+		start, err := time.Parse(models.QuietHoursLayout, *input.QuietStart)
+		if err != nil {
+			a.error(c, http.StatusBadRequest, "quiet_start must be in the form hh:mm")
+			return
+		}
+
+		end, err := time.Parse(models.QuietHoursLayout, *input.QuietEnd)
+		if err != nil {
+			a.error(c, http.StatusBadRequest, "quiet_end must be in the form hh:mm")
+			return
+		}
+
+		if start.Equal(end) {
+			a.error(c, http.StatusBadRequest, "Quiet hours can't start and end at the same time")
+			return
+		}
+	}
+
+	_, err := a.DB.Exec(
+		`insert into robot_docking(robot_id, quiet_start, quiet_end, timezone, min_battery) values ($1, $2, $3, $4, $5)
+		on conflict (robot_id) do update set quiet_start = $2, quiet_end = $3, timezone = $4, min_battery = $5`,
+		robot.ID, input.QuietStart, input.QuietEnd, input.Timezone, input.MinBattery,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.sendDockingSchedule(robot.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RobotDockPost sends the robot back to its dock
+func (a *API) RobotDockPost(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	if !isRobotConnected(robot.ID) {
+		c.JSON(http.StatusFailedDependency, gin.H{
+			"message": "Robot not connected",
+		})
+		return
+	}
+
+	payload := gin.H{"reason": "user"}
+	a.sendRobotMessage(robot.ID, "DOCK", payload)
+
+	a.audit(c, "robot.dock", "robot", robot.ID.String(), robot.UserID, payload)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"strconv"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 
 	"github.com/jmoiron/sqlx/types"
 	"github.com/teamxiv/growbot-api/internal/models"
@@ -15,7 +14,8 @@ import (
 )
 
 // EventCheck is a middleware to check whether the passed event id exists,
-// and (if logged in) confirms whether the currently logged in user owns that event
+// and (if logged in) confirms whether the currently logged in user owns that event,
+// or is a member of the household it is shared with
 func (a *API) EventCheck(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -33,12 +33,12 @@ func (a *API) EventCheck(c *gin.Context) {
 		return
 	}
 
-	_, loggedIn := c.Get("user_id")
-	if uid := event.UserID; loggedIn && uid != c.GetInt("user_id") {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "error",
-			"message": "You don't own that event",
-		})
+	if !a.accessCheck(c, &event.UserID, event.HouseholdID, "event") {
+		c.Abort()
+		return
+	}
+
+	if !a.scopeCheck(c, models.ScopeEventsRead, models.ScopeEventsWrite) {
 		c.Abort()
 		return
 	}
@@ -70,13 +70,24 @@ type expandedEvent struct {
 	Action []models.EventAction `json:"actions"`
 }
 
+// expandedEventsByUserID returns the events owned by the user
 func (a *API) expandedEventsByUserID(userID int) ([]expandedEvent, error) {
+	return a.expandedEvents("e.user_id=$1", userID)
+}
+
+// expandedEventsVisibleTo returns the events owned by the user, and those shared with their households.
+// archived is a condition from archivedFilter.
+func (a *API) expandedEventsVisibleTo(userID int, archived string) ([]expandedEvent, error) {
+	return a.expandedEvents("(e.user_id=$1 or e.household_id in "+sqlMyHouseholds+")"+archived, userID)
+}
+
+func (a *API) expandedEvents(where string, args ...interface{}) ([]expandedEvent, error) {
 	events := []struct {
 		models.Event
 		Actions types.JSONText `json:"actions" db:"actions"`
 	}{}
 
-	err := a.DB.Select(&events, "select e.*, json_agg(a) as actions from event_actions as a, events as e where e.user_id=$1 and a.event_id=e.id group by e.id", userID)
+	err := a.DB.Select(&events, "select e.*, json_agg(a) as actions from event_actions as a, events as e where "+where+" and a.event_id=e.id group by e.id", args...)
 	if err != nil {
 		return nil, err
 	}
@@ -95,12 +106,8 @@ func (a *API) expandedEventsByUserID(userID int) ([]expandedEvent, error) {
 }
 
 func (a *API) pingRobotEvents(rid uuid.UUID, boot bool) {
-	robotCtxsMutex.Lock()
-	c, ok := robotCtxs[rid]
-	robotCtxsMutex.Unlock()
-
 	// If not connected, stop
-	if !ok {
+	if !isRobotConnected(rid) {
 		return
 	}
 
@@ -114,7 +121,13 @@ func (a *API) pingRobotEvents(rid uuid.UUID, boot bool) {
 		query = "and not e.ephemeral"
 	}
 
-	err := a.DB.Select(&events, "select e.*, json_agg(a) as actions from event_actions as a, events as e where a.robot_id=$1 and a.event_id=e.id "+query+" group by e.id", rid)
+	// Archived events, and actions on archived plants, aren't carried out
+	query += " and e.archived_at is null and not exists(select 1 from plants where id = a.plant_id and archived_at is not null)"
+
+	// Watering is held back while the robot's tank is nearly empty
+	query += " and not (a.name = $2 and exists(select 1 from robot_alerts where robot_id = $1 and watering_paused))"
+
+	err := a.DB.Select(&events, "select e.*, json_agg(a) as actions from event_actions as a, events as e where a.robot_id=$1 and a.event_id=e.id "+query+" group by e.id", rid, models.EventActionPlantWater)
 	if err != nil {
 		a.Log.WithError(err).WithField("rid", rid).Warnln("could not get events from db")
 		return
@@ -132,21 +145,78 @@ func (a *API) pingRobotEvents(rid uuid.UUID, boot bool) {
 			a.Log.WithError(err).WithField("rid", rid).Warnln("could not unmarshal actions")
 			return
 		}
-	}
 
-	ws := c.MustGet("ws").(*websocket.Conn)
+		// Routes are sent with all of their steps. Those that can't be expanded (e.g. the route is gone,
+		// or no longer shared with the robot) are left out.
+		actions := result[i].Action[:0]
+		for _, action := range result[i].Action {
+			if action.Name == models.EventActionRunRoute {
+				if err := a.expandRouteAction(&action); err != nil {
+					a.Log.WithError(err).WithField("rid", rid).WithField("action_id", action.ID).Warnln("could not expand route")
+					continue
+				}
+			}
+			actions = append(actions, action)
+		}
+		result[i].Action = actions
+	}
 
 	msg := struct {
 		Type string          `json:"type"`
 		Data []expandedEvent `json:"data"`
 	}{"events", result}
 
-	err = ws.WriteJSON(msg)
+	_, err = writeRobot(rid, msg)
 	if err != nil {
 		a.Log.WithError(err).WithField("rid", rid).Warnln("could not send events")
 	}
 }
 
+// isRobotConnected returns whether the robot currently has a websocket open
+func isRobotConnected(rid uuid.UUID) bool {
+	robotCtxsMutex.Lock()
+	_, ok := robotCtxs[rid]
+	robotCtxsMutex.Unlock()
+	return ok
+}
+
+// runNow sends a single action to a robot straight away, as an ephemeral event
+// (which is removed once it has been sent to the robot).
+//
+// The robot should be connected, otherwise the event is only sent once something else pings its events.
+// Returns errQuietHours or errLowBattery if the robot has to stay docked.
+func (a *API) runNow(userID int, summary string, action models.EventAction) error {
+	if err := a.canDispatch(action.RobotID); err != nil {
+		return err
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var eventID int
+	if err := tx.Get(&eventID, "insert into events(summary, user_id, ephemeral) values ($1, $2, true) returning id", summary, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"insert into event_actions(event_id, name, plant_id, robot_id, data) values ($1, $2, $3, $4, $5)",
+		eventID, action.Name, action.PlantID, action.RobotID, action.Data,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.pingRobotEvents(action.RobotID, false)
+	return nil
+}
+
 // func (a *API) pingUserEvents(uid *int, rid *uuid.UUID) {
 // 	var events []expandedEvent
 
@@ -231,7 +301,12 @@ func (a *API) pingRobotEvents(rid uuid.UUID, boot bool) {
 func (a *API) EventListGet(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	events, err := a.expandedEventsByUserID(userID)
+	archived, ok := a.archivedFilter(c, "e")
+	if !ok {
+		return
+	}
+
+	events, err := a.expandedEventsVisibleTo(userID, archived)
 	if err != nil {
 		a.error(c, http.StatusInternalServerError, err.Error())
 		return
@@ -242,6 +317,44 @@ func (a *API) EventListGet(c *gin.Context) {
 	})
 }
 
+// validateEventActions checks the actions of an event the user is creating.
+// Returns a message if they're invalid, or use robots or plants the user can't.
+func (a *API) validateEventActions(userID int, actions []models.EventAction) (string, error) {
+	for _, action := range actions {
+		if ok, err := a.canUseRobot(userID, action.RobotID); err != nil {
+			return "", err
+		} else if !ok {
+			return "Robot does not exist", nil
+		}
+
+		if action.PlantID != nil {
+			if ok, err := a.canUsePlant(userID, *action.PlantID); err != nil {
+				return "", err
+			} else if !ok {
+				return "Plant does not exist", nil
+			}
+		}
+
+		// Routes are checked here, as the robot is sent their steps
+		if action.Name != models.EventActionRunRoute {
+			continue
+		}
+
+		routeID, ok := routeActionID(&action)
+		if !ok {
+			return "RUN_ROUTE actions need a route_id", nil
+		}
+
+		if ok, err := a.canUseRoute(userID, routeID); err != nil {
+			return "", err
+		} else if !ok {
+			return "Route does not exist", nil
+		}
+	}
+
+	return "", nil
+}
+
 // EventCreatePost gets the plant object
 func (a *API) EventCreatePost(c *gin.Context) {
 	userID := c.GetInt("user_id")
@@ -256,14 +369,34 @@ func (a *API) EventCreatePost(c *gin.Context) {
 		return
 	}
 
+	// Events can be shared with a household straight away
+	if hid := input.HouseholdID; hid != nil {
+		role, err := a.householdRole(userID, *hid)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if role == "" || role == models.HouseholdRoleViewer {
+			a.error(c, http.StatusForbidden, "You can't share things with that household")
+			return
+		}
+	}
+
+	if msg, err := a.validateEventActions(userID, input.Actions); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if msg != "" {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
 	hasActions := len(input.Actions) > 0
 
-	query := `insert into events (summary, recurrence, user_id, ephemeral) values ($1, $2, $3, $4) returning id`
+	query := `insert into events (summary, recurrence, user_id, ephemeral, household_id) values ($1, $2, $3, $4, $5) returning id`
 	if hasActions {
 		query = "with inserted as (" + query + ")"
 	}
 
-	args := []interface{}{input.Event.Summary, input.Recurrences, userID, input.Ephemeral}
+	args := []interface{}{input.Event.Summary, input.Recurrences, userID, input.Ephemeral, input.HouseholdID}
 	rids := make(map[uuid.UUID]struct{})
 
 	for i, action := range input.Actions {
@@ -305,16 +438,24 @@ func (a *API) EventPut(c *gin.Context) {
 	c.JSON(http.StatusNotImplemented, gin.H{"message": "not implemented (yet)"})
 }
 
-// EventDelete gets the plant object
+// EventDelete archives the event, so that it stops being sent to robots. It can be restored until the retention period is over.
 func (a *API) EventDelete(c *gin.Context) {
 	event := c.MustGet("event").(*models.Event)
 
-	_, err := a.DB.Exec("delete from events where id=$1", event.ID)
+	if !a.ownerCheck(c, &event.UserID, "event") {
+		return
+	}
+
+	_, err := a.DB.Exec("update events set archived_at = timezone('utc', now()) where id = $1 and archived_at is null", event.ID)
 	if err != nil {
 		a.error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	a.pingEventRobots(event.ID)
+
+	a.audit(c, "event.delete", "event", strconv.Itoa(event.ID), &event.UserID, nil)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+	"gocloud.dev/blob"
+)
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+	ExportStatusExpired = "expired"
+)
+
+// Export is a data export requested by a user
+type Export struct {
+	ID           int        `json:"id" db:"id"`
+	UserID       int        `json:"user_id" db:"user_id"`
+	Status       string     `json:"status" db:"status"`
+	Progress     int        `json:"progress" db:"progress"`
+	Filename     uuid.UUID  `json:"-" db:"filename"`
+	TokenHash    *string    `json:"-" db:"token_hash"`
+	Error        *string    `json:"error,omitempty" db:"error"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at" db:"expires_at"`
+	DownloadedAt *time.Time `json:"downloaded_at" db:"downloaded_at"`
+}
+
+func exportBucketKey(id uuid.UUID) string {
+	return "exports." + id.String()
+}
+
+// MeExportPost starts exporting everything we hold about the current user.
+//
+// Progress is reported on the user stream (EXPORT_PROGRESS), and once finished
+// a download link is sent on the user stream (EXPORT_READY) and by email.
+func (a *API) MeExportPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var busy bool
+	err := a.DB.Get(&busy, "select exists(select 1 from exports where user_id = $1 and status in ($2, $3))", userID, ExportStatusPending, ExportStatusRunning)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if busy {
+		a.error(c, http.StatusConflict, "An export is already in progress")
+		return
+	}
+
+	export := Export{
+		UserID:   userID,
+		Status:   ExportStatusPending,
+		Filename: uuid.New(),
+	}
+
+	err = a.DB.Get(&export.ID, "insert into exports(user_id, status, filename) values ($1, $2, $3) returning id", export.UserID, export.Status, export.Filename)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	go a.runExport(export)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id": export.ID,
+	})
+}
+
+// MeExportListGet lists the exports of the current user
+func (a *API) MeExportListGet(c *gin.Context) {
+	exports := []Export{}
+
+	err := a.DB.Select(&exports, "select * from exports where user_id = $1 order by created_at desc", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exports": exports,
+	})
+}
+
+// ExportDownloadGet serves a finished export. Each link only works once.
+func (a *API) ExportDownloadGet(c *gin.Context) {
+	var export Export
+
+	err := a.DB.Get(&export, "update exports set downloaded_at = timezone('utc', now()) where token_hash = $1 and status = $2 and downloaded_at is null and expires_at > timezone('utc', now()) returning *", hashToken(c.Param("token")), ExportStatusDone)
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusNotFound, "This link is invalid, has expired, or has already been used")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	key := exportBucketKey(export.Filename)
+
+	r, err := a.Bucket.NewReader(c, key, nil)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer r.Close()
+
+	filename := fmt.Sprintf("growbot-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Content-Length", strconv.FormatInt(r.Size(), 10))
+
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		a.Log.WithError(err).WithField("export_id", export.ID).Warnln("Could not send export")
+	}
+
+	if err := a.Bucket.Delete(c, key); err != nil {
+		a.Log.WithError(err).WithField("export_id", export.ID).Warnln("Could not delete downloaded export")
+	}
+}
+
+// exportProgress updates and reports the progress of an export
+func (a *API) exportProgress(export *Export, status string, progress int) {
+	export.Status = status
+	export.Progress = progress
+
+	_, err := a.DB.Exec("update exports set status = $2, progress = $3 where id = $1", export.ID, status, progress)
+	if err != nil {
+		a.Log.WithError(err).WithField("export_id", export.ID).Warnln("Could not update export progress")
+	}
+
+	a.userStreams.transmit(export.UserID, "EXPORT_PROGRESS", map[string]interface{}{
+		"id":       export.ID,
+		"status":   status,
+		"progress": progress,
+	})
+}
+
+// runExport builds the archive for an export in the bucket
+func (a *API) runExport(export Export) {
+	ctx := context.Background()
+	log := a.Log.WithField("export_id", export.ID).WithField("user_id", export.UserID)
+
+	a.exportProgress(&export, ExportStatusRunning, 0)
+
+	err := a.writeExport(ctx, &export)
+	if err != nil {
+		log.WithError(err).Warnln("Export failed")
+
+		msg := err.Error()
+		if _, err := a.DB.Exec("update exports set error = $2 where id = $1", export.ID, msg); err != nil {
+			log.WithError(err).Warnln("Could not store export error")
+		}
+		a.exportProgress(&export, ExportStatusFailed, export.Progress)
+
+		_ = a.Bucket.Delete(ctx, exportBucketKey(export.Filename))
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		log.WithError(err).Warnln("Could not generate export token")
+		a.exportProgress(&export, ExportStatusFailed, export.Progress)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(a.Config.ExportExpiry)
+	_, err = a.DB.Exec("update exports set token_hash = $2, expires_at = $3 where id = $1", export.ID, hashToken(token), expiresAt)
+	if err != nil {
+		log.WithError(err).Warnln("Could not store export token")
+		a.exportProgress(&export, ExportStatusFailed, export.Progress)
+		return
+	}
+
+	a.exportProgress(&export, ExportStatusDone, 100)
+
+	link := a.Config.PublicURL + "/exports/" + url.PathEscape(token)
+
+	a.userStreams.transmit(export.UserID, "EXPORT_READY", map[string]interface{}{
+		"id":         export.ID,
+		"url":        link,
+		"expires_at": expiresAt,
+	})
+
+	var email string
+	if err := a.DB.Get(&email, "select email from users where id = $1", export.UserID); err != nil {
+		log.WithError(err).Warnln("Could not get email address for export")
+		return
+	}
+
+	body := "The export of your GrowBot data is ready. You can download it once, until " +
+		expiresAt.Format(time.RFC1123) + ", from:\n\n" + link
+	if err := a.Mailer.Send(email, "Your GrowBot data export is ready", body); err != nil {
+		log.WithError(err).Warnln("Could not send export email")
+	}
+
+	log.Infoln("Export done")
+}
+
+// writeExport writes the zip archive, with a JSON file for each kind of data and all plant photos
+func (a *API) writeExport(ctx context.Context, export *Export) error {
+	userID := export.UserID
+
+	var user models.User
+	if err := a.DB.Get(&user, "select * from users where id = $1", userID); err != nil {
+		return err
+	}
+
+	plants := []models.Plant{}
+	if err := a.DB.Select(&plants, "select * from plants where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	events, err := a.expandedEventsByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	entries := []LogEntry{}
+	if err := a.DB.Select(&entries, "select * from log where user_id = $1 order by created_at", userID); err != nil {
+		return err
+	}
+
+	robots := []struct {
+		ID uuid.UUID `json:"id" db:"robot_id"`
+		models.Robot
+		models.RobotState
+	}{}
+	if err := a.DB.Select(&robots, "select robots.id as robot_id,created_at,updated_at,robot_state.*,title from robots,robot_state where robots.user_id=$1 and robot_state.id=robots.id", userID); err != nil {
+		return err
+	}
+
+	tokens := []models.AccessToken{}
+	if err := a.DB.Select(&tokens, "select * from access_tokens where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	profiles := []models.CareProfile{}
+	if err := a.DB.Select(&profiles, "select * from care_profiles where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	rules := []models.WateringRule{}
+	if err := a.DB.Select(&rules, "select * from watering_rules where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	waterings := []models.PlantWatering{}
+	if err := a.DB.Select(&waterings, "select w.* from plants as p, plant_waterings as w where p.user_id = $1 and w.plant_id = p.id order by w.created_at", userID); err != nil {
+		return err
+	}
+
+	zones := []models.Zone{}
+	if err := a.DB.Select(&zones, "select * from zones where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	identifiers := []models.PlantIdentifier{}
+	if err := a.DB.Select(&identifiers, "select i.* from plants as p, plant_identifiers as i where p.user_id = $1 and i.plant_id = p.id order by i.id", userID); err != nil {
+		return err
+	}
+
+	routes := []models.Route{}
+	if err := a.DB.Select(&routes, "select * from routes where user_id = $1", userID); err != nil {
+		return err
+	}
+
+	journal := []models.JournalEntry{}
+	if err := a.DB.Select(&journal, "select j.* from plants as p, plant_journal as j where p.user_id = $1 and j.plant_id = p.id order by j.created_at", userID); err != nil {
+		return err
+	}
+
+	photos := []models.PlantPhoto{}
+	if err := a.DB.Select(&photos, "select ph.* from plants as pl, plant_photos as ph where pl.user_id = $1 and ph.plant_id = pl.id order by ph.created_at", userID); err != nil {
+		return err
+	}
+
+	// Cancelling the context passed to NewWriter discards whatever has been written
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := a.Bucket.NewWriter(wctx, exportBucketKey(export.Filename), &blob.WriterOptions{ContentType: "application/zip"})
+	if err != nil {
+		return err
+	}
+
+	abort := func(err error) error {
+		cancel()
+		w.Close()
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		Name string
+		Data interface{}
+	}{
+		{"profile.json", user},
+		{"plants.json", plants},
+		{"events.json", events},
+		{"log.json", entries},
+		{"robots.json", robots},
+		{"access_tokens.json", tokens},
+		{"care_profiles.json", profiles},
+		{"watering_rules.json", rules},
+		{"waterings.json", waterings},
+		{"journal.json", journal},
+		{"zones.json", zones},
+		{"plant_identifiers.json", identifiers},
+		{"routes.json", routes},
+		{"photos.json", photos},
+	}
+
+	total := len(files) + len(photos)
+	done := 0
+	step := func() {
+		done++
+		a.exportProgress(export, ExportStatusRunning, done*100/(total+1))
+	}
+
+	names := []string{}
+	for _, file := range files {
+		f, err := zw.Create(file.Name)
+		if err != nil {
+			return abort(err)
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.Data); err != nil {
+			return abort(err)
+		}
+
+		names = append(names, file.Name)
+		step()
+	}
+
+	for _, photo := range photos {
+		name := fmt.Sprintf("photos/%d.jpg", photo.ID)
+		if err := a.exportPhoto(ctx, zw, name, photo); err != nil {
+			return abort(err)
+		}
+
+		names = append(names, name)
+		step()
+	}
+
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return abort(err)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(map[string]interface{}{
+		"user_id":    userID,
+		"created_at": time.Now().UTC(),
+		"files":      names,
+	})
+	if err != nil {
+		return abort(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return abort(err)
+	}
+
+	return w.Close()
+}
+
+// exportPhoto copies a photo from the bucket into the archive.
+// Photos missing from the bucket are skipped.
+func (a *API) exportPhoto(ctx context.Context, zw *zip.Writer, name string, photo models.PlantPhoto) error {
+	key := photoBucketKey(photo.Filename)
+
+	exists, err := a.Bucket.Exists(ctx, key)
+	if err != nil {
+		return err
+	} else if !exists {
+		a.Log.WithField("photo_id", photo.ID).Warnln("Photo missing from bucket during export")
+		return nil
+	}
+
+	r, err := a.Bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// cleanupExports periodically removes exports that were never downloaded in time.
+// Exports left pending or running by a previous run of the server are failed first, so they don't block new ones.
+func (a *API) cleanupExports() {
+	stale := []Export{}
+	err := a.DB.Select(&stale, "update exports set status = $1, error = $2 where status in ($3, $4) returning *", ExportStatusFailed, "Interrupted by a server restart", ExportStatusPending, ExportStatusRunning)
+	if err != nil {
+		a.Log.WithError(err).Warnln("Could not fail interrupted exports")
+	}
+
+	for _, export := range stale {
+		// The export may not have been written yet, so there is nothing to complain about if this fails
+		_ = a.Bucket.Delete(context.Background(), exportBucketKey(export.Filename))
+	}
+
+	for range time.Tick(time.Hour) {
+		exports := []Export{}
+		err := a.DB.Select(&exports, "update exports set status = $1 where status = $2 and expires_at < timezone('utc', now()) returning *", ExportStatusExpired, ExportStatusDone)
+		if err != nil {
+			a.Log.WithError(err).Warnln("Could not expire exports")
+			continue
+		}
+
+		for _, export := range exports {
+			if export.DownloadedAt != nil {
+				continue
+			}
+
+			if err := a.Bucket.Delete(context.Background(), exportBucketKey(export.Filename)); err != nil {
+				a.Log.WithError(err).WithField("export_id", export.ID).Warnln("Could not delete expired export")
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// PlantHealthThreshold is the health score below which a plant is considered unwell, and a warning is logged
+const PlantHealthThreshold = 50
+
+// HealthWateringDays is how many days of waterings are used to judge how regularly a plant is watered
+const HealthWateringDays = 14
+
+// HealthPhotoDays is how many days of photos are used to judge whether a plant is growing
+const HealthPhotoDays = 30
+
+// updatePlantHealth recomputes the health of the plant from its moisture, waterings and photos.
+// A warning is logged when its health drops below PlantHealthThreshold.
+func (a *API) updatePlantHealth(plantID int) {
+	logger := a.Log.WithField("plant_id", plantID)
+
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select * from plants where id = $1", plantID); err != nil {
+		logger.WithError(err).Warnln("Could not get plant to update its health")
+		return
+	}
+
+	var profile *models.CareProfile
+	if plant.CareProfileID != nil {
+		profile = &models.CareProfile{}
+		if err := a.DB.Get(profile, "select * from care_profiles where id = $1", *plant.CareProfileID); err != nil {
+			logger.WithError(err).Warnln("Could not get care profile to update plant health")
+			return
+		}
+	}
+
+	waterings := []models.PlantWatering{}
+	err := a.DB.Select(
+		&waterings,
+		"select * from plant_waterings where plant_id = $1 and created_at > $2 order by created_at",
+		plantID, time.Now().UTC().AddDate(0, 0, -HealthWateringDays),
+	)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not get waterings to update plant health")
+		return
+	}
+
+	coverage := []float64{}
+	err = a.DB.Select(
+		&coverage,
+		"select green_coverage from plant_photos where plant_id = $1 and green_coverage is not null and archived_at is null and created_at > $2 order by created_at",
+		plantID, time.Now().UTC().AddDate(0, 0, -HealthPhotoDays),
+	)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not get photos to update plant health")
+		return
+	}
+
+	factors := []models.HealthFactor{}
+	for _, f := range []*models.HealthFactor{
+		models.MoistureHealth(profile, plant.SoilMoisture),
+		models.WateringHealth(waterings),
+		models.GrowthHealth(coverage),
+	} {
+		if f != nil {
+			factors = append(factors, *f)
+		}
+	}
+
+	health := models.HealthScore(factors)
+	factorsJSON, err := json.Marshal(factors)
+	if err != nil {
+		return
+	}
+
+	_, err = a.DB.Exec(
+		"update plants set health = $2, health_factors = $3, health_at = timezone('utc', now()) where id = $1",
+		plantID, health, types.JSONText(factorsJSON),
+	)
+	if err != nil {
+		logger.WithError(err).Warnln("Could not update plant health")
+		return
+	}
+
+	a.transmitShared(plant.UserID, plant.HouseholdID, "PLANT_HEALTH", gin.H{
+		"plant_id": plantID,
+		"health":   health,
+		"factors":  factors,
+	})
+
+	// Only log when the plant becomes unwell, not every time it is recomputed while unwell
+	wasWell := plant.Health == nil || *plant.Health >= PlantHealthThreshold
+	if health == nil || *health >= PlantHealthThreshold || !wasWell {
+		return
+	}
+
+	reasons := []string{}
+	for _, f := range factors {
+		if f.Score < PlantHealthThreshold {
+			reasons = append(reasons, f.Message)
+		}
+	}
+
+	entry := LogEntry{
+		UserID:   plant.UserID,
+		Type:     "plant_health",
+		Message:  fmt.Sprintf("%s's health has dropped to %d", plant.Name, *health),
+		Severity: LogSeverityWarning,
+		PlantID:  &plant.ID,
+	}
+	if len(reasons) > 0 {
+		entry.Message += ". " + strings.Join(reasons, ". ")
+	}
+
+	if err := a.insertLogEntry(&entry); err != nil {
+		logger.WithError(err).Warnln("Could not insert log entry for plant health")
+		return
+	}
+
+	a.transmitShared(entry.UserID, plant.HouseholdID, "CREATE_LOG_ENTRY", entry)
+}
@@ -0,0 +1,675 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// HouseholdInviteTimeout is how long a household invitation is valid for
+const HouseholdInviteTimeout = time.Hour * 24 * 7
+
+// sqlMyHouseholds is a subquery for the ids of the households user $1 is a member of
+const sqlMyHouseholds = "(select household_id from household_members where user_id = $1)"
+
+// householdRole returns the role of the user in the household, or "" if they aren't a member
+func (a *API) householdRole(userID int, householdID int) (string, error) {
+	var role string
+	err := a.DB.Get(&role, "select role from household_members where household_id = $1 and user_id = $2", householdID, userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// accessCheck confirms whether the currently logged in user can access a row
+// owned by ownerID, that may be shared with the household householdID.
+//
+// Owners can do anything. Household members can read, and (unless they are a viewer)
+// change the row. If not logged in, access is always allowed, like the other *Check middlewares.
+//
+// Responds and returns false if access is denied.
+func (a *API) accessCheck(c *gin.Context, ownerID *int, householdID *int, what string) bool {
+	if _, loggedIn := c.Get("user_id"); !loggedIn {
+		return true
+	}
+
+	userID := c.GetInt("user_id")
+	if ownerID != nil && *ownerID == userID {
+		return true
+	}
+
+	if householdID != nil {
+		role, err := a.householdRole(userID, *householdID)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return false
+		}
+
+		if role != "" && (role != models.HouseholdRoleViewer || c.Request.Method == http.MethodGet) {
+			return true
+		} else if role != "" {
+			a.error(c, http.StatusForbidden, "Viewers can't change that "+what)
+			return false
+		}
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"status":  "error",
+		"message": "you don't own that " + what,
+	})
+	return false
+}
+
+// ownerCheck only lets the owner of a row through. Household members can't delete
+// (or give away) things they don't own.
+//
+// Responds and returns false if they aren't the owner.
+func (a *API) ownerCheck(c *gin.Context, ownerID *int, what string) bool {
+	if ownerID != nil && *ownerID == c.GetInt("user_id") {
+		return true
+	}
+
+	a.error(c, http.StatusForbidden, "Only the owner of that "+what+" can do this")
+	return false
+}
+
+// transmitShared transmits a message to the owner of a row, and to the members of the household it is shared with
+func (a *API) transmitShared(ownerID int, householdID *int, msgType string, data interface{}) {
+	a.userStreams.transmit(ownerID, msgType, data)
+
+	if householdID == nil {
+		return
+	}
+
+	members := []int{}
+	err := a.DB.Select(&members, "select user_id from household_members where household_id = $1 and user_id != $2", *householdID, ownerID)
+	if err != nil {
+		a.Log.WithError(err).WithField("household_id", *householdID).Warnln("Could not get household members to transmit to")
+		return
+	}
+
+	for _, uid := range members {
+		a.userStreams.transmit(uid, msgType, data)
+	}
+}
+
+// setHousehold shares a row of the given table with a household (or stops sharing it, if household_id is null).
+//
+// Only the owner of the row can do this, and they must be allowed to change things in the household.
+func (a *API) setHousehold(c *gin.Context, table string, id interface{}, ownerID *int) {
+	userID := c.GetInt("user_id")
+
+	if ownerID == nil || *ownerID != userID {
+		a.error(c, http.StatusForbidden, "Only the owner can change who this is shared with")
+		return
+	}
+
+	input := struct {
+		HouseholdID *int `json:"household_id"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.HouseholdID != nil {
+		role, err := a.householdRole(userID, *input.HouseholdID)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if role == "" || role == models.HouseholdRoleViewer {
+			a.error(c, http.StatusForbidden, "You can't share things with that household")
+			return
+		}
+	}
+
+	// table is never user input
+	_, err := a.DB.Exec("update "+table+" set household_id = $2 where id = $1", id, input.HouseholdID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "household.share", table, fmt.Sprint(id), &userID, input)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// HouseholdCheck is a middleware to check whether the passed household exists,
+// and that the currently logged in user is a member of it
+func (a *API) HouseholdCheck(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		c.Abort()
+		return
+	}
+
+	household := models.Household{}
+	err = a.DB.Get(&household, "select * from households where id = $1", id)
+	if err != nil {
+		BadRequest(c, "Household does not exist ("+err.Error()+")")
+		c.Abort()
+		return
+	}
+
+	role, err := a.householdRole(c.GetInt("user_id"), household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		c.Abort()
+		return
+	} else if role == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "you aren't a member of that household",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("household", &household)
+	c.Set("household_role", role)
+}
+
+// HouseholdOwnerCheck is a middleware that only lets household owners through. Use after HouseholdCheck.
+func (a *API) HouseholdOwnerCheck(c *gin.Context) {
+	if c.GetString("household_role") != models.HouseholdRoleOwner {
+		a.error(c, http.StatusForbidden, "Only owners of the household can do that")
+		c.Abort()
+	}
+}
+
+// HouseholdListGet lists the households the current user is a member of
+func (a *API) HouseholdListGet(c *gin.Context) {
+	households := []struct {
+		models.Household
+		Role string `json:"role" db:"role"`
+	}{}
+
+	err := a.DB.Select(&households, "select h.*, m.role from households as h, household_members as m where m.user_id = $1 and m.household_id = h.id order by h.name", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"households": households,
+	})
+}
+
+// HouseholdCreatePost creates a household, with the current user as its owner
+func (a *API) HouseholdCreatePost(c *gin.Context) {
+	input := struct {
+		Name string `json:"name"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Households must have a name")
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.Get(&id, "insert into households(name) values ($1) returning id", input.Name); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = tx.Exec("insert into household_members(household_id, user_id, role) values ($1, $2, $3)", id, c.GetInt("user_id"), models.HouseholdRoleOwner)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// HouseholdGet gets the household, along with its members
+func (a *API) HouseholdGet(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	result := struct {
+		models.Household
+		Role    string `json:"role"`
+		Members []struct {
+			models.HouseholdMember
+			Forename string `json:"forename" db:"forename"`
+			Surname  string `json:"surname" db:"surname"`
+			Email    string `json:"email" db:"email"`
+		} `json:"members"`
+	}{Household: *household, Role: c.GetString("household_role")}
+
+	err := a.DB.Select(&result.Members, "select m.*, u.forename, u.surname, u.email from household_members as m, users as u where m.household_id = $1 and u.id = m.user_id order by m.created_at", household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// HouseholdRenamePatch renames the household
+func (a *API) HouseholdRenamePatch(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	input := struct {
+		Name string `json:"name"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Households must have a name")
+		return
+	}
+
+	_, err := a.DB.Exec("update households set name = $2 where id = $1", household.ID, input.Name)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// sqlHouseholdRules is a condition for watering rules using a robot or plant that is only theirs to use through household $1
+const sqlHouseholdRules = "(w.robot_id in (select id from robots where household_id = $1 and user_id != w.user_id) or w.plant_id in (select id from plants where household_id = $1 and user_id != w.user_id))"
+
+// HouseholdDelete deletes the household. Anything shared with it goes back to only being visible to its owner,
+// and watering rules members set up with each other's robots and plants are removed.
+func (a *API) HouseholdDelete(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	_, err := a.DB.Exec("with r as (delete from watering_rules as w where "+sqlHouseholdRules+") delete from households where id = $1", household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ownerCount returns how many owners the household has
+func (a *API) ownerCount(householdID int) (int, error) {
+	var n int
+	err := a.DB.Get(&n, "select count(*) from household_members where household_id = $1 and role = $2", householdID, models.HouseholdRoleOwner)
+	return n, err
+}
+
+// HouseholdMemberPatch changes the role of a member. Takes a "role".
+func (a *API) HouseholdMemberPatch(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	input := struct {
+		Role string `json:"role"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !models.ValidHouseholdRole(input.Role) {
+		a.error(c, http.StatusBadRequest, "Unknown role "+input.Role)
+		return
+	}
+
+	role, err := a.householdRole(userID, household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if role == "" {
+		a.error(c, http.StatusNotFound, "That user isn't a member of this household")
+		return
+	}
+
+	if role == models.HouseholdRoleOwner && input.Role != models.HouseholdRoleOwner {
+		if n, err := a.ownerCount(household.ID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if n < 2 {
+			a.error(c, http.StatusBadRequest, "Households must have at least one owner")
+			return
+		}
+	}
+
+	_, err = a.DB.Exec("update household_members set role = $3 where household_id = $1 and user_id = $2", household.ID, userID, input.Role)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// HouseholdMemberDelete removes a member from the household.
+//
+// Owners can remove anyone, and everyone can remove themselves (leave).
+// Robots, plants and events the member shared with the household stop being shared,
+// and watering rules that relied on the household between them and other members are removed.
+func (a *API) HouseholdMemberDelete(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	if userID != c.GetInt("user_id") && c.GetString("household_role") != models.HouseholdRoleOwner {
+		a.error(c, http.StatusForbidden, "Only owners of the household can do that")
+		return
+	}
+
+	role, err := a.householdRole(userID, household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if role == "" {
+		a.error(c, http.StatusNotFound, "That user isn't a member of this household")
+		return
+	}
+
+	if role == models.HouseholdRoleOwner {
+		if n, err := a.ownerCount(household.ID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if n < 2 {
+			a.error(c, http.StatusBadRequest, "Households must have at least one owner, delete the household instead")
+			return
+		}
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"delete from watering_rules as w where " + sqlHouseholdRules + " and (w.user_id = $2 or w.robot_id in (select id from robots where user_id = $2) or w.plant_id in (select id from plants where user_id = $2))",
+		"delete from household_members where household_id = $1 and user_id = $2",
+		"update robots set household_id = null where household_id = $1 and user_id = $2",
+		"update plants set household_id = null where household_id = $1 and user_id = $2",
+		"update events set household_id = null where household_id = $1 and user_id = $2",
+		"update zones set household_id = null where household_id = $1 and user_id = $2",
+		"update routes set household_id = null where household_id = $1 and user_id = $2",
+	} {
+		if _, err := tx.Exec(query, household.ID, userID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The household's robots no longer look after the member's zones and plants
+	a.pingPlantMaps(userID, &household.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// HouseholdInviteListGet lists the pending invitations of the household
+func (a *API) HouseholdInviteListGet(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	invites := []models.HouseholdInvite{}
+	err := a.DB.Select(&invites, "select * from household_invites where household_id = $1 and expires_at > timezone('utc', now()) order by created_at desc", household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites": invites,
+	})
+}
+
+// HouseholdInvitePost invites someone to the household. Takes a "role", and optionally an "email".
+//
+// With an email address, the invitation is emailed to them.
+// Without one, a link is returned that can be shared with anyone.
+func (a *API) HouseholdInvitePost(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	input := struct {
+		Email *string `json:"email"`
+		Role  string  `json:"role"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !models.ValidHouseholdRole(input.Role) {
+		a.error(c, http.StatusBadRequest, "Unknown role "+input.Role)
+		return
+	}
+
+	if input.Email != nil {
+		if ok, msg := validateEmail(*input.Email); !ok {
+			a.error(c, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	invite := models.HouseholdInvite{
+		HouseholdID: household.ID,
+		Email:       input.Email,
+		Role:        input.Role,
+		TokenHash:   hashToken(token),
+		InvitedBy:   c.GetInt("user_id"),
+		ExpiresAt:   time.Now().UTC().Add(HouseholdInviteTimeout),
+	}
+
+	rows, err := a.DB.NamedQuery("insert into household_invites(household_id, email, role, token_hash, invited_by, expires_at) values (:household_id, :email, :role, :token_hash, :invited_by, :expires_at) returning id", invite)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		a.error(c, http.StatusInternalServerError, "Expected rows.Next() to return true")
+		return
+	}
+
+	if err := rows.Scan(&invite.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	link := a.Config.FrontendURL + "/invite?token=" + url.QueryEscape(token)
+
+	if input.Email != nil {
+		body := "You have been invited to join the household \"" + household.Name + "\" on GrowBot, as a " + input.Role + ".\n\n" +
+			"To accept, log in (or create an account with this email address) and follow this link within 7 days:\n\n" + link
+
+		if err := a.Mailer.Send(*input.Email, "You've been invited to a GrowBot household", body); err != nil {
+			a.Log.WithError(err).WithField("household_id", household.ID).Warnln("Could not send household invitation")
+			a.error(c, http.StatusInternalServerError, "Could not send invitation email")
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"id": invite.ID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":  invite.ID,
+		"url": link,
+	})
+}
+
+// HouseholdInviteDelete revokes an invitation
+func (a *API) HouseholdInviteDelete(c *gin.Context) {
+	household := c.MustGet("household").(*models.Household)
+
+	id, err := strconv.Atoi(c.Param("invite_id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	res, err := a.DB.Exec("delete from household_invites where id = $1 and household_id = $2", id, household.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		a.error(c, http.StatusNotFound, "Invite does not exist")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// InviteAcceptPost takes the "token" of an invitation, and adds the current user to that household
+func (a *API) InviteAcceptPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var invite models.HouseholdInvite
+	err = tx.Get(&invite, "select * from household_invites where token_hash = $1 and expires_at > timezone('utc', now())", hashToken(input.Token))
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusBadRequest, "This invitation is invalid or has expired")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if invite.Email != nil {
+		var email string
+		if err := tx.Get(&email, "select email from users where id = $1", userID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if email != *invite.Email {
+			a.error(c, http.StatusForbidden, "This invitation was sent to a different email address")
+			return
+		}
+
+		if _, err := tx.Exec("delete from household_invites where id = $1", invite.ID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	_, err = tx.Exec("insert into household_members(household_id, user_id, role) values ($1, $2, $3)", invite.HouseholdID, userID, invite.Role)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		a.error(c, http.StatusConflict, "You are already a member of this household")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"household_id": invite.HouseholdID,
+	})
+}
+
+// RobotHouseholdPut shares the robot with a household. Takes a "household_id", which can be null to stop sharing it.
+func (a *API) RobotHouseholdPut(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+	a.setHousehold(c, "robots", robot.ID, robot.UserID)
+}
+
+// PlantHouseholdPut shares the plant with a household. Takes a "household_id", which can be null to stop sharing it.
+func (a *API) PlantHouseholdPut(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+	a.setHousehold(c, "plants", plant.ID, &plant.UserID)
+}
+
+// EventHouseholdPut shares the event with a household. Takes a "household_id", which can be null to stop sharing it.
+func (a *API) EventHouseholdPut(c *gin.Context) {
+	event := c.MustGet("event").(*models.Event)
+	a.setHousehold(c, "events", event.ID, &event.UserID)
+}
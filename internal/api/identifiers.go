@@ -0,0 +1,388 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/lib/pq"
+	"github.com/skip2/go-qrcode"
+	"github.com/teamxiv/growbot-api/internal/models"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+// PlantQRPrefix starts the payload of the QR codes we generate for plants
+const PlantQRPrefix = "growbot:plant:"
+
+// PlantLabelsMax is the most plants a sheet of labels can have
+const PlantLabelsMax = 120
+
+// Layout of a sheet of labels, in pixels for PNGs
+const (
+	labelColumns = 3
+	labelQRSize  = 300
+	labelWidth   = 400
+	labelHeight  = 380
+)
+
+// normaliseNFC upper-cases the UID of an NFC tag and strips separators from it.
+// Returns "" if it isn't a UID.
+func normaliseNFC(uid string) string {
+	uid = strings.ToUpper(strings.NewReplacer(":", "", "-", "", " ", "").Replace(uid))
+
+	// UIDs are 4, 7 or 10 bytes long
+	if b, err := hex.DecodeString(uid); err != nil || (len(b) != 4 && len(b) != 7 && len(b) != 10) {
+		return ""
+	}
+	return uid
+}
+
+// PlantIdentifierListGet lists the QR codes and NFC tags of the plant
+func (a *API) PlantIdentifierListGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	identifiers := []models.PlantIdentifier{}
+	if err := a.DB.Select(&identifiers, "select * from plant_identifiers where plant_id = $1 order by id", plant.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"identifiers": identifiers,
+	})
+}
+
+// PlantIdentifierCreatePost binds a QR code or NFC tag to the plant. Takes the "kind" (qr or nfc) and its "value".
+// QR codes without a value are given a new one, which can be printed with PlantLabelsGet.
+func (a *API) PlantIdentifierCreatePost(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch input.Kind {
+	case models.PlantIdentifierQR:
+		input.Value = strings.TrimSpace(input.Value)
+		if input.Value == "" {
+			input.Value = PlantQRPrefix + uuid.New().String()
+		}
+
+	case models.PlantIdentifierNFC:
+		input.Value = normaliseNFC(input.Value)
+		if input.Value == "" {
+			a.error(c, http.StatusBadRequest, "NFC tags need the UID of the tag, in hex")
+			return
+		}
+
+	default:
+		a.error(c, http.StatusBadRequest, "kind must be one of qr or nfc")
+		return
+	}
+
+	var id int
+	err := a.DB.Get(&id, "insert into plant_identifiers(plant_id, user_id, kind, value) values ($1, $2, $3, $4) returning id", plant.ID, plant.UserID, input.Kind, input.Value)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		a.error(c, http.StatusConflict, "That "+input.Kind+" tag is already bound to another of the owner's plants")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":    id,
+		"value": input.Value,
+	})
+}
+
+// PlantIdentifierDelete unbinds a QR code or NFC tag from the plant
+func (a *API) PlantIdentifierDelete(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	id, err := strconv.Atoi(c.Param("identifier_id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	res, err := a.DB.Exec("delete from plant_identifiers where id = $1 and plant_id = $2", id, plant.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		a.error(c, http.StatusNotFound, "Identifier does not exist")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// plantLabel is a plant to print a QR code for
+type plantLabel struct {
+	PlantID int    `db:"plant_id"`
+	Name    string `db:"name"`
+	Value   string `db:"value"`
+}
+
+// PlantLabelsGet generates a printable sheet of QR codes for the user's plants (or those given with "plant_id"),
+// as a "png" or "pdf" (the default) depending on "format". Only plants that already have a QR code are included.
+func (a *API) PlantLabelsGet(c *gin.Context) {
+	a.plantLabels(c, false)
+}
+
+// PlantLabelsPost is PlantLabelsGet, except that plants the user can change are given a QR code first if they have none
+func (a *API) PlantLabelsPost(c *gin.Context) {
+	a.plantLabels(c, true)
+}
+
+func (a *API) plantLabels(c *gin.Context, create bool) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Format   string  `form:"format,default=pdf"`
+		PlantIDs []int64 `form:"plant_id"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Format != "png" && input.Format != "pdf" {
+		a.error(c, http.StatusBadRequest, "format must be one of png or pdf")
+		return
+	}
+
+	visible := "p.archived_at is null and (p.user_id = $2 or p.household_id in (select household_id from household_members where user_id = $2)) and ($1::integer[] is null or p.id = any($1))"
+	args := []interface{}{nil, userID}
+	if len(input.PlantIDs) > 0 {
+		args[0] = pq.Array(input.PlantIDs)
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	if create {
+		_, err = tx.Exec(
+			`insert into plant_identifiers(plant_id, user_id, kind, value)
+			select p.id, p.user_id, 'qr', $3 || md5(random()::text || p.id::text)::uuid::text from plants as p
+			where `+visible+` and p.id in (select id from plants where `+sqlCanChange+`)
+			and not exists(select 1 from plant_identifiers where plant_id = p.id and kind = 'qr')`,
+			append(args, PlantQRPrefix)...,
+		)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	labels := []plantLabel{}
+	err = tx.Select(
+		&labels,
+		`select distinct on (p.id) p.id as plant_id, p.name, i.value from plants as p, plant_identifiers as i
+		where i.plant_id = p.id and i.kind = 'qr' and `+visible+` order by p.id, i.id`,
+		args...,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(labels) == 0 {
+		a.error(c, http.StatusBadRequest, "No plants to make labels for")
+		return
+	} else if len(labels) > PlantLabelsMax {
+		a.error(c, http.StatusBadRequest, fmt.Sprintf("Labels can only be made for %d plants at a time", PlantLabelsMax))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if input.Format == "png" {
+		err = labelsPNG(&buf, labels)
+	} else {
+		err = labelsPDF(&buf, labels)
+	}
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\"plant-labels."+input.Format+"\"")
+	c.Data(http.StatusOK, map[string]string{"png": "image/png", "pdf": "application/pdf"}[input.Format], buf.Bytes())
+}
+
+// labelsPNG draws the labels in a grid, each a QR code with the name of the plant underneath
+func labelsPNG(w io.Writer, labels []plantLabel) error {
+	rows := (len(labels) + labelColumns - 1) / labelColumns
+	img := image.NewRGBA(image.Rect(0, 0, labelColumns*labelWidth, rows*labelHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, label := range labels {
+		qr, err := qrcode.New(label.Value, qrcode.Medium)
+		if err != nil {
+			return err
+		}
+
+		x := (i % labelColumns) * labelWidth
+		y := (i / labelColumns) * labelHeight
+		at := image.Pt(x+(labelWidth-labelQRSize)/2, y+20)
+
+		draw.Draw(img, image.Rect(at.X, at.Y, at.X+labelQRSize, at.Y+labelQRSize), qr.Image(labelQRSize), image.Point{}, draw.Src)
+
+		d := &font.Drawer{
+			Dst:  img,
+			Src:  image.Black,
+			Face: inconsolata.Bold8x16,
+		}
+
+		name := label.Name
+		if r := []rune(name); len(r) > 40 {
+			name = string(r[:37]) + "..."
+		}
+
+		d.Dot = fixed.Point26_6{
+			X: fixed.I(x) + (fixed.I(labelWidth)-d.MeasureString(name))/2,
+			Y: fixed.I(y + labelQRSize + 50),
+		}
+		d.DrawString(name)
+	}
+
+	return png.Encode(w, img)
+}
+
+// labelsPDF lays the labels out on A4 pages, twelve to a page
+func labelsPDF(w io.Writer, labels []plantLabel) error {
+	const perPage = labelColumns * 4
+	const cellWidth, cellHeight, qrSize = 70.0, 70.0, 50.0
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetFont("Helvetica", "", 10)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	for i, label := range labels {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+
+		b, err := qrcode.Encode(label.Value, qrcode.Medium, 512)
+		if err != nil {
+			return err
+		}
+
+		name := "qr" + strconv.Itoa(i)
+		opts := gofpdf.ImageOptions{ImageType: "PNG"}
+		pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(b))
+
+		x := 10 + float64(i%labelColumns)*cellWidth
+		y := 10 + float64((i%perPage)/labelColumns)*cellHeight
+
+		pdf.ImageOptions(name, x+(cellWidth-qrSize)/2, y, qrSize, qrSize, false, opts, 0, "")
+		pdf.SetXY(x, y+qrSize+2)
+		pdf.CellFormat(cellWidth, 6, tr(label.Name), "", 0, "C", false, 0, "")
+	}
+
+	return pdf.Output(w)
+}
+
+// streamRobotPlantTagScanned is sent by the robot when it reads a QR code or NFC tag, with its "value"
+// and optionally its "kind". The robot is sent PLANT_TAG_RESOLVED with the "plant_id" and "name"
+// of the plant, and the "actions" it has pending for it, or the "reason" it couldn't be resolved.
+func (a *API) streamRobotPlantTagScanned(data map[string]interface{}, robot *models.Robot) {
+	value, _ := data["value"].(string)
+	kind, _ := data["kind"].(string)
+
+	result := gin.H{
+		"value":    value,
+		"plant_id": nil,
+	}
+	defer func() {
+		a.sendRobotMessage(robot.ID, "PLANT_TAG_RESOLVED", result)
+	}()
+
+	// Robots that don't say what they scanned get whichever kind matches
+	nfc := normaliseNFC(value)
+	if kind == models.PlantIdentifierNFC && nfc == "" {
+		result["reason"] = "unknown_tag"
+		return
+	}
+
+	if robot.UserID == nil {
+		result["reason"] = "unknown_tag"
+		return
+	}
+
+	// Tags are only unique per owner, so only the plants the robot can serve are looked at, its owner's first
+	identifier := models.PlantIdentifier{}
+	err := a.DB.Get(
+		&identifier,
+		`select i.* from plant_identifiers as i, plants as p
+		where i.plant_id = p.id and p.archived_at is null and (p.user_id = $4 or (p.household_id is not null and p.household_id = $5))
+		and ((i.kind = 'qr' and i.value = $1) or (i.kind = 'nfc' and i.value = $2)) and ($3 = '' or i.kind::text = $3)
+		order by p.user_id = $4 desc, i.kind, i.id limit 1`,
+		value, nfc, kind, *robot.UserID, robot.HouseholdID,
+	)
+	if err == sql.ErrNoRows {
+		result["reason"] = "unknown_tag"
+		return
+	} else if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not resolve tag for PLANT_TAG_SCANNED")
+		result["reason"] = "error"
+		return
+	}
+
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select * from plants where id = $1 and archived_at is null", identifier.PlantID); err != nil || !robotCanServe(robot, &plant) {
+		result["reason"] = "unknown_tag"
+		return
+	}
+
+	actions := []models.EventAction{}
+	err = a.DB.Select(
+		&actions,
+		"select a.* from event_actions as a, events as e where a.event_id = e.id and e.archived_at is null and a.plant_id = $1 and a.robot_id = $2 order by a.id",
+		plant.ID, robot.ID,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not get actions for PLANT_TAG_SCANNED")
+		result["reason"] = "error"
+		return
+	}
+
+	result["kind"] = identifier.Kind
+	result["plant_id"] = plant.ID
+	result["name"] = plant.Name
+	result["actions"] = actions
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// PlantMaxTags is the most tags a plant can have
+const PlantMaxTags = 20
+
+// PlantMaxTagLength is the longest a tag can be
+const PlantMaxTagLength = 32
+
+// PlantMaxFields is the most custom fields a plant can have
+const PlantMaxFields = 50
+
+// normaliseTags lowercases and trims the tags, dropping empty ones and duplicates
+func normaliseTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result
+}
+
+// validateTags normalises the tags, returning a message if there are too many or any are too long
+func validateTags(tags []string) ([]string, string) {
+	tags = normaliseTags(tags)
+	if len(tags) > PlantMaxTags {
+		return nil, "Plants can't have more than " + strconv.Itoa(PlantMaxTags) + " tags"
+	}
+
+	for _, tag := range tags {
+		if len(tag) > PlantMaxTagLength {
+			return nil, "Tags can't be longer than " + strconv.Itoa(PlantMaxTagLength) + " characters"
+		}
+	}
+
+	return tags, ""
+}
+
+// PlantTagsPut replaces the tags of the plant. Takes "tags", a list of strings.
+func (a *API) PlantTagsPut(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		Tags []string `json:"tags"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags, msg := validateTags(input.Tags)
+	if msg != "" {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	if _, err := a.DB.Exec("update plants set tags = $2 where id = $1", plant.ID, pq.Array(tags)); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"tags":   tags,
+	})
+}
+
+// PlantFieldsPatch updates the custom fields of the plant. Takes a JSON object, where each
+// value is a string, number or boolean. Fields set to null are removed, and others are left alone.
+func (a *API) PlantFieldsPatch(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := map[string]interface{}{}
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for key, value := range input {
+		if key == "" || len(key) > 64 {
+			a.error(c, http.StatusBadRequest, "Field names must be between 1 and 64 characters")
+			return
+		}
+
+		switch value.(type) {
+		case nil, string, float64, bool:
+		default:
+			a.error(c, http.StatusBadRequest, "Field "+key+" must be a string, number or boolean")
+			return
+		}
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var fields types.JSONText
+	err = a.DB.Get(
+		&fields,
+		"update plants set fields = jsonb_strip_nulls(fields || $2) where id = $1 and (select count(*) from jsonb_object_keys(jsonb_strip_nulls(fields || $2))) <= $3 returning fields",
+		plant.ID, types.JSONText(data), PlantMaxFields,
+	)
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusBadRequest, "Plants can't have more than "+strconv.Itoa(PlantMaxFields)+" fields")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"fields": fields,
+	})
+}
+
+// journalInput is what can be written in a journal entry
+type journalInput struct {
+	Body    string `json:"body"`
+	PhotoID *int   `json:"photo_id"`
+
+	// CreatedAt allows entries to be backdated, and defaults to now
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// validateJournalInput checks the entry, and that its photo is of the plant. Responds and returns false if it's invalid.
+func (a *API) validateJournalInput(c *gin.Context, plant *models.Plant, input *journalInput) bool {
+	if strings.TrimSpace(input.Body) == "" {
+		a.error(c, http.StatusBadRequest, "Journal entries can't be empty")
+		return false
+	}
+
+	if input.PhotoID != nil {
+		var ok bool
+		if err := a.DB.Get(&ok, "select exists(select 1 from plant_photos where id = $1 and plant_id = $2)", *input.PhotoID, plant.ID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return false
+		} else if !ok {
+			a.error(c, http.StatusBadRequest, "That photo is not of this plant")
+			return false
+		}
+	}
+
+	return true
+}
+
+// journalEntry gets the entry in the "entry_id" param, which has to belong to the plant.
+// Responds and returns nil if it doesn't exist.
+func (a *API) journalEntry(c *gin.Context, plant *models.Plant) *models.JournalEntry {
+	id, err := strconv.Atoi(c.Param("entry_id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return nil
+	}
+
+	var entry models.JournalEntry
+	err = a.DB.Get(&entry, "select * from plant_journal where id = $1 and plant_id = $2", id, plant.ID)
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusNotFound, "Journal entry does not exist")
+		return nil
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	return &entry
+}
+
+// PlantJournalListGet lists the journal entries of the plant, most recent first
+func (a *API) PlantJournalListGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		Limit  int `form:"limit,default=50"`
+		Offset int `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries := []models.JournalEntry{}
+	err := a.DB.Select(&entries, "select * from plant_journal where plant_id = $1 order by created_at desc limit $2 offset $3", plant.ID, input.Limit, input.Offset)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+// PlantJournalCreatePost writes a journal entry about the plant.
+// Takes a "body", and optionally a "photo_id" of the plant and a "created_at".
+func (a *API) PlantJournalCreatePost(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	var input journalInput
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !a.validateJournalInput(c, plant, &input) {
+		return
+	}
+
+	createdAt := time.Now().UTC()
+	if input.CreatedAt != nil {
+		createdAt = input.CreatedAt.UTC()
+	}
+
+	var id int
+	err := a.DB.Get(
+		&id,
+		"insert into plant_journal(plant_id, user_id, body, photo_id, created_at) values ($1, $2, $3, $4, $5) returning id",
+		plant.ID, c.GetInt("user_id"), input.Body, input.PhotoID, createdAt,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// PlantJournalPut rewrites a journal entry. Takes the same as PlantJournalCreatePost.
+func (a *API) PlantJournalPut(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	entry := a.journalEntry(c, plant)
+	if entry == nil {
+		return
+	}
+
+	var input journalInput
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !a.validateJournalInput(c, plant, &input) {
+		return
+	}
+
+	createdAt := entry.CreatedAt
+	if input.CreatedAt != nil {
+		createdAt = input.CreatedAt.UTC()
+	}
+
+	_, err := a.DB.Exec(
+		"update plant_journal set body = $2, photo_id = $3, created_at = $4, updated_at = timezone('utc', now()) where id = $1",
+		entry.ID, input.Body, input.PhotoID, createdAt,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// PlantJournalDelete deletes a journal entry
+func (a *API) PlantJournalDelete(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	entry := a.journalEntry(c, plant)
+	if entry == nil {
+		return
+	}
+
+	if _, err := a.DB.Exec("delete from plant_journal where id = $1", entry.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"database/sql"
 	"errors"
+	"sync"
+	"time"
 
 	jwt "github.com/appleboy/gin-jwt"
 	"github.com/gin-gonic/gin"
@@ -9,14 +12,60 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var errIncorrectLogin = errors.New("incorrect email or password")
+var errLockedOut = errors.New("too many failed attempts")
+
+// dummyHash is compared against when an email doesn't exist,
+// so that response times don't reveal which emails have accounts
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("growbot dummy password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
 func (a *API) jwtUnauthorized(c *gin.Context, code int, message string) {
+	// The authenticator sets this when someone has been locked out
+	if wait, ok := c.Get("retry_after"); ok {
+		a.tooManyRequests(c, wait.(time.Duration))
+		return
+	}
+
 	c.JSON(code, gin.H{
 		"code":    code,
 		"message": message,
 	})
 }
 
+// jwtAuthorizator is called on every request made with a JWT.
+//
+// The role and activation status are always read from the database rather than the claims,
+// so that deactivating someone or taking away their admin role takes effect immediately.
+// The role is set as "user_role" for AdminCheck.
 func (a *API) jwtAuthorizator(data interface{}, c *gin.Context) bool {
+	userID, ok := data.(int)
+	if !ok {
+		return false
+	}
+
+	var user models.User
+	err := a.DB.Get(&user, "select is_activated, role from users where id = $1", userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			a.Log.WithError(err).WithField("user_id", userID).Warnln("Could not look up user to authorize")
+		}
+		return false
+	}
+
+	if !user.Activated {
+		return false
+	}
+
+	c.Set("user_role", user.Role)
+
 	return true
 }
 
@@ -30,22 +79,67 @@ func (a *API) jwtAuthenticator(c *gin.Context) (interface{}, error) {
 		return "", jwt.ErrMissingLoginValues
 	}
 
+	ipKey := "ip:" + c.ClientIP()
+	accountKey := accountLimitKey(input.Email)
+
+	if wait := a.limiter.locked(accountKey); wait > 0 {
+		c.Set("retry_after", wait)
+		return "", errLockedOut
+	}
+
+	// fail records the failed attempt, locking out the account and IP if necessary.
+	// ownerID is the user that was being logged in to, if the email address exists.
+	fail := func(ownerID *int) (interface{}, error) {
+		a.insertAudit(AuditEntry{
+			Credential: AuditCredentialPassword,
+			IP:         optionalString(c.ClientIP()),
+			Action:     "auth.login.failed",
+			OwnerID:    ownerID,
+		})
+
+		wait := a.limiter.fail(accountKey)
+		if ipWait := a.limiter.fail(ipKey); ipWait > wait {
+			wait = ipWait
+		}
+
+		if wait > 0 {
+			a.Log.WithField("ip", c.ClientIP()).WithField("email", input.Email).Warnln("Locked out after failed logins")
+		}
+
+		return "", errIncorrectLogin
+	}
+
 	var user models.User
 
-	err := a.DB.Get(&user, "select id,password,is_activated from users where email = $1 limit 1", input.Email)
-	if err != nil {
-		return "", err
+	err := a.DB.Get(&user, "select id,password,is_activated,role from users where email = $1 limit 1", input.Email)
+	if err == sql.ErrNoRows {
+		compareDummyHash(input.Password)
+		return fail(nil)
+	} else if err != nil {
+		a.Log.WithError(err).Warnln("Could not look up user for login")
+		return "", errors.New("could not log in, please try again later")
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
 	if err != nil {
-		return "", err
+		return fail(&user.ID)
 	}
 
+	a.limiter.succeed(accountKey)
+	a.limiter.succeed(ipKey)
+
 	if !user.Activated {
 		return "", errors.New("account not activated")
 	}
 
+	a.insertAudit(AuditEntry{
+		ActorID:    &user.ID,
+		Credential: AuditCredentialPassword,
+		IP:         optionalString(c.ClientIP()),
+		Action:     "auth.login",
+		OwnerID:    &user.ID,
+	})
+
 	return &user, nil
 }
 
@@ -58,7 +152,8 @@ func (a *API) jwtIdentityHandler(c *gin.Context) interface{} {
 func (a *API) jwtPayloadFunc(data interface{}) jwt.MapClaims {
 	if v, ok := data.(*models.User); ok {
 		return jwt.MapClaims{
-			"id": v.ID,
+			"id":   v.ID,
+			"role": v.Role,
 		}
 	}
 	return jwt.MapClaims{}
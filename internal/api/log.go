@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -27,6 +28,21 @@ type LogEntry struct {
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 }
 
+// insertLogEntry inserts the entry into the log, filling in its id and created_at
+func (a *API) insertLogEntry(entry *LogEntry) error {
+	rows, err := a.DB.NamedQuery("insert into log(user_id, type, message, severity, robot_id, plant_id) values (:user_id, :type, :message, :severity, :robot_id, :plant_id) returning id, created_at", entry)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return errors.New("expected rows.Next() to return true")
+	}
+
+	return rows.StructScan(entry)
+}
+
 // LogListGet returns a list of log entries
 func (a *API) LogListGet(c *gin.Context) {
 	userID := c.GetInt("user_id")
@@ -44,8 +60,9 @@ func (a *API) LogListGet(c *gin.Context) {
 		return
 	}
 
+	// Entries about robots and plants shared with our households are included too
 	args := []interface{}{userID}
-	query := "user_id=$1"
+	query := "(user_id=$1 or robot_id in (select id from robots where household_id in " + sqlMyHouseholds + ") or plant_id in (select id from plants where household_id in " + sqlMyHouseholds + "))"
 	queryIndex := 1
 
 	if input.RobotID != nil {
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+	"gocloud.dev/blob"
+)
+
+// RobotMapMaxSize is the largest map image robots can upload, in bytes
+const RobotMapMaxSize = 8 << 20
+
+// RobotMapVersionsKept is how many versions of its map are kept for each robot. Older ones are deleted on upload.
+const RobotMapVersionsKept = 10
+
+func mapBucketKey(id uuid.UUID) string {
+	return "robotmaps." + id.String()
+}
+
+func mapContentType(format string) string {
+	if format == models.MapFormatPNG {
+		return "image/png"
+	}
+	return "image/x-portable-graymap"
+}
+
+// deleteMapFiles removes map images from the bucket, after their rows have been deleted
+func (a *API) deleteMapFiles(filenames []uuid.UUID) {
+	for _, filename := range filenames {
+		if err := a.Bucket.Delete(context.Background(), mapBucketKey(filename)); err != nil {
+			a.Log.WithError(err).WithField("filename", filename).Warnln("Could not delete robot map from bucket")
+		}
+	}
+}
+
+// streamRobotMapUpload is sent by the robot with a new version of its map. Takes the "image" (base64),
+// its "format" (pgm or png), "width" and "height" in pixels, "resolution" in metres per pixel,
+// and "origin_x" and "origin_y" in metres.
+//
+// The annotations of the previous version are carried over to the new one, and only the latest
+// RobotMapVersionsKept versions are kept.
+func (a *API) streamRobotMapUpload(ctx *gin.Context, data map[string]interface{}, robot *models.Robot) {
+	image, _ := data["image"].(string)
+	format, _ := data["format"].(string)
+	resolution, _ := data["resolution"].(float64)
+	width, _ := data["width"].(float64)
+	height, _ := data["height"].(float64)
+	originX, _ := data["origin_x"].(float64)
+	originY, _ := data["origin_y"].(float64)
+
+	if format != models.MapFormatPGM && format != models.MapFormatPNG {
+		a.Log.WithField("rid", robot.ID).WithField("format", format).Warnln("Unknown format for MAP_UPLOAD")
+		return
+	} else if resolution <= 0 || width <= 0 || height <= 0 {
+		a.Log.WithField("rid", robot.ID).Warnln("Invalid dimensions for MAP_UPLOAD")
+		return
+	} else if base64.StdEncoding.DecodedLen(len(image)) > RobotMapMaxSize {
+		a.Log.WithField("rid", robot.ID).Warnln("Map too large for MAP_UPLOAD")
+		return
+	}
+
+	img, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		a.Log.WithField("rid", robot.ID).WithError(err).Warnln("Could not decode image for MAP_UPLOAD")
+		return
+	}
+
+	filename := uuid.New()
+	key := mapBucketKey(filename)
+
+	if err := a.Bucket.WriteAll(ctx, key, img, &blob.WriterOptions{ContentType: mapContentType(format)}); err != nil {
+		a.Log.WithField("rid", robot.ID).WithError(err).Warnln("Could not write map to bucket for MAP_UPLOAD")
+		return
+	}
+
+	var m models.RobotMap
+	err = a.DB.Get(
+		&m,
+		`insert into robot_maps(robot_id, version, filename, format, resolution, origin_x, origin_y, width, height, annotations) values (
+			$1,
+			coalesce((select max(version) from robot_maps where robot_id = $1), 0) + 1,
+			$2, $3, $4, $5, $6, $7, $8,
+			coalesce((select annotations from robot_maps where robot_id = $1 order by version desc limit 1), '{}')
+		) returning *`,
+		robot.ID, filename, format, resolution, originX, originY, int(width), int(height),
+	)
+	if err != nil {
+		_ = a.Bucket.Delete(ctx, key)
+		a.Log.WithField("rid", robot.ID).WithError(err).Warnln("Could not insert map for MAP_UPLOAD")
+		return
+	}
+
+	var old []uuid.UUID
+	err = a.DB.Select(&old, "delete from robot_maps where robot_id = $1 and version <= $2 returning filename", robot.ID, m.Version-RobotMapVersionsKept)
+	if err != nil {
+		a.Log.WithField("rid", robot.ID).WithError(err).Warnln("Could not delete old maps for MAP_UPLOAD")
+	}
+	a.deleteMapFiles(old)
+
+	a.Log.WithField("rid", robot.ID).WithField("version", m.Version).Infoln("MAP_UPLOAD done")
+
+	if robot.UserID != nil {
+		a.transmitShared(*robot.UserID, robot.HouseholdID, "ROBOT_MAP_UPDATED", m)
+	}
+}
+
+// sendRobotMap sends the latest map of the robot, with its annotations, to the robot (if it is connected)
+func (a *API) sendRobotMap(ctx context.Context, rid uuid.UUID) {
+	if !isRobotConnected(rid) {
+		return
+	}
+
+	var m models.RobotMap
+	err := a.DB.Get(&m, "select * from robot_maps where robot_id = $1 order by version desc limit 1", rid)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not get map for MAP")
+		return
+	}
+
+	img, err := a.Bucket.ReadAll(ctx, mapBucketKey(m.Filename))
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not read map from bucket for MAP")
+		return
+	}
+
+	payload := struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{
+		Type: "MAP",
+		Data: struct {
+			models.RobotMap
+			Image string `json:"image"`
+		}{m, base64.StdEncoding.EncodeToString(img)},
+	}
+
+	if _, err := writeRobot(rid, payload); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not send MAP")
+	}
+}
+
+// robotMap gets the version of the robot's map in the "version" param, which can also be "latest".
+// Responds and returns nil if it doesn't exist.
+func (a *API) robotMap(c *gin.Context, robot *models.Robot) *models.RobotMap {
+	query := "select * from robot_maps where robot_id = $1 order by version desc limit 1"
+	args := []interface{}{robot.ID}
+
+	if v := c.Param("version"); v != "latest" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			BadRequest(c, err.Error())
+			return nil
+		}
+
+		query = "select * from robot_maps where robot_id = $1 and version = $2"
+		args = append(args, version)
+	}
+
+	var m models.RobotMap
+	err := a.DB.Get(&m, query, args...)
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusNotFound, "Map does not exist")
+		return nil
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	return &m
+}
+
+// RobotMapListGet lists the versions of the robot's map, newest first
+func (a *API) RobotMapListGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	maps := []models.RobotMap{}
+	if err := a.DB.Select(&maps, "select * from robot_maps where robot_id = $1 order by version desc", robot.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"maps": maps,
+	})
+}
+
+// RobotMapGet gets a version of the robot's map, along with its annotations
+func (a *API) RobotMapGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	m := a.robotMap(c, robot)
+	if m == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
+// RobotMapImageGet serves the image of a version of the robot's map
+func (a *API) RobotMapImageGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	m := a.robotMap(c, robot)
+	if m == nil {
+		return
+	}
+
+	r, err := a.Bucket.NewReader(c, mapBucketKey(m.Filename), nil)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, "This map is in our database, but could not be found.")
+		return
+	}
+	defer r.Close()
+
+	c.Header("Content-Type", mapContentType(m.Format))
+	c.Header("Content-Length", strconv.FormatInt(r.Size(), 10))
+
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not serve robot map")
+	}
+}
+
+// RobotMapAnnotationsPut replaces the annotations of a version of the robot's map:
+// "no_go" zones (each a "name" and at least three "points" of [x, y]) and "plants" (each a "plant_id", "x" and "y").
+//
+// Plants have to be ones the robot looks after. If this is the latest version, it is sent to the robot straight away.
+func (a *API) RobotMapAnnotationsPut(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	m := a.robotMap(c, robot)
+	if m == nil {
+		return
+	}
+
+	var input models.MapAnnotations
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.NoGo == nil {
+		input.NoGo = []models.NoGoZone{}
+	}
+	if input.Plants == nil {
+		input.Plants = []models.MapPlantPoint{}
+	}
+
+	for _, zone := range input.NoGo {
+		if len(zone.Points) < 3 {
+			a.error(c, http.StatusBadRequest, "No-go zones need at least three points")
+			return
+		}
+	}
+
+	plantIDs := []int64{}
+	seen := map[int]bool{}
+	for _, plant := range input.Plants {
+		if seen[plant.PlantID] {
+			a.error(c, http.StatusBadRequest, "Plants can only be on the map once")
+			return
+		}
+		seen[plant.PlantID] = true
+		plantIDs = append(plantIDs, int64(plant.PlantID))
+	}
+
+	if len(plantIDs) > 0 {
+		if robot.UserID == nil {
+			a.error(c, http.StatusBadRequest, "This robot doesn't look after any plants")
+			return
+		}
+
+		var count int
+		err := a.DB.Get(&count, "select count(*) from plants where id = any($1) and (user_id = $2 or (household_id is not null and household_id = $3))", pq.Array(plantIDs), *robot.UserID, robot.HouseholdID)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if count != len(plantIDs) {
+			a.error(c, http.StatusBadRequest, "Some of those plants aren't looked after by this robot")
+			return
+		}
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := a.DB.Exec("update robot_maps set annotations = $2 where id = $1", m.ID, types.JSONText(data)); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var latest bool
+	if err := a.DB.Get(&latest, "select not exists(select 1 from robot_maps where robot_id = $1 and version > $2)", robot.ID, m.Version); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if latest {
+		a.sendRobotMap(c, robot.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
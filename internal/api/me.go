@@ -0,0 +1,309 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// EmailChangeTimeout is how long an email change confirmation link is valid for
+const EmailChangeTimeout = time.Hour * 24
+
+// MeGet returns the profile of the current user
+func (a *API) MeGet(c *gin.Context) {
+	var user models.User
+
+	err := a.DB.Get(&user, "select * from users where id = $1", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// MePatch updates the forename and/or surname of the current user
+func (a *API) MePatch(c *gin.Context) {
+	input := struct {
+		Forename *string `json:"forename"`
+		Surname  *string `json:"surname"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err := a.DB.Exec(
+		"update users set forename = coalesce($2, forename), surname = coalesce($3, surname), updated_at = timezone('utc', now()) where id = $1",
+		c.GetInt("user_id"), input.Forename, input.Surname,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// MeEmailPost starts changing the email address of the current user.
+//
+// Takes the new "email" and the current "password". The email address is only
+// changed once the link sent to the new address has been followed.
+func (a *API) MeEmailPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if ok, msg := validateEmail(input.Email); !ok {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	if !a.checkPassword(c, userID, input.Password) {
+		return
+	}
+
+	var taken bool
+	if err := a.DB.Get(&taken, "select exists(select 1 from users where email = $1)", input.Email); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if taken {
+		a.error(c, http.StatusConflict, "That email address is already in use")
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Only one change can be pending at a time
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from email_changes where user_id = $1", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = tx.Exec(
+		"insert into email_changes(user_id, email, token_hash, expires_at) values ($1, $2, $3, $4)",
+		userID, input.Email, hashToken(token), time.Now().UTC().Add(EmailChangeTimeout),
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	link := a.Config.FrontendURL + "/confirm-email?token=" + url.QueryEscape(token)
+	body := "Someone (hopefully you) asked to change the email address of their GrowBot account to this one.\n\n" +
+		"To confirm the change, follow this link within 24 hours:\n\n" + link + "\n\n" +
+		"If this wasn't you, you can ignore this email."
+
+	if err := a.Mailer.Send(input.Email, "Confirm your new GrowBot email address", body); err != nil {
+		a.Log.WithError(err).WithField("user_id", userID).Warnln("Could not send email change confirmation")
+		a.error(c, http.StatusInternalServerError, "Could not send confirmation email")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "auth.email.change_requested", "user", strconv.Itoa(userID), &userID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Check your inbox to confirm your new email address",
+	})
+}
+
+// AuthEmailConfirmPost takes the "token" from an email change confirmation link,
+// and changes the email address of that user.
+func (a *API) AuthEmailConfirmPost(c *gin.Context) {
+	input := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	change := struct {
+		UserID   int    `db:"user_id"`
+		Email    string `db:"email"`
+		OldEmail string `db:"old_email"`
+	}{}
+
+	err = tx.Get(&change, "delete from email_changes as e using users as u where e.token_hash = $1 and e.expires_at > timezone('utc', now()) and u.id = e.user_id returning e.user_id, e.email, u.email as old_email", hashToken(input.Token))
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusBadRequest, "This link is invalid or has expired")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = tx.Exec("update users set email = $2, updated_at = timezone('utc', now()) where id = $1", change.UserID, change.Email)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		a.error(c, http.StatusConflict, "That email address is already in use")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Let the old address know, in case this wasn't them
+	body := "The email address of your GrowBot account has been changed to " + change.Email + ".\n\n" +
+		"If this wasn't you, please get in touch with us."
+	if err := a.Mailer.Send(change.OldEmail, "Your GrowBot email address has changed", body); err != nil {
+		a.Log.WithError(err).WithField("user_id", change.UserID).Warnln("Could not notify old email address")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Your email address has been changed",
+	})
+}
+
+// MeDelete deletes the account of the current user. Takes the current "password".
+//
+// Robots are unregistered (so they can be registered again), photos and exports are
+// removed from the bucket, and everything else goes with the user row.
+//
+// Users who are the only owner of a household with other members have to hand it over (or delete it) first.
+func (a *API) MeDelete(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Password string `json:"password"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !a.checkPassword(c, userID, input.Password) {
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var soleOwner bool
+	err = tx.Get(
+		&soleOwner,
+		`select exists(select 1 from household_members as m where m.user_id = $1 and m.role = $2
+			and not exists(select 1 from household_members as o where o.household_id = m.household_id and o.user_id != $1 and o.role = $2)
+			and exists(select 1 from household_members as o where o.household_id = m.household_id and o.user_id != $1))`,
+		userID, models.HouseholdRoleOwner,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if soleOwner {
+		a.error(c, http.StatusConflict, "Households must have at least one owner, make someone else an owner of your households or delete them first")
+		return
+	}
+
+	filenames := []uuid.UUID{}
+	err = tx.Select(&filenames, "select ph.filename from plants as pl, plant_photos as ph where pl.user_id = $1 and ph.plant_id = pl.id", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	exports := []uuid.UUID{}
+	err = tx.Select(&exports, "select filename from exports where user_id = $1", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Household members may have set up watering rules with the robots
+	if _, err := tx.Exec("delete from watering_rules where robot_id in (select id from robots where user_id = $1)", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := tx.Exec("update robots set user_id = null, title = '', household_id = null where user_id = $1", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := tx.Exec("delete from users where id = $1", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The rows are gone, so failing to remove a file only leaves an orphan behind
+	for _, filename := range filenames {
+		if err := a.Bucket.Delete(c, photoBucketKey(filename)); err != nil {
+			a.Log.WithError(err).WithField("filename", filename).Warnln("Could not delete photo of deleted user")
+		}
+	}
+
+	for _, filename := range exports {
+		key := exportBucketKey(filename)
+		if exists, _ := a.Bucket.Exists(c, key); exists {
+			if err := a.Bucket.Delete(c, key); err != nil {
+				a.Log.WithError(err).WithField("filename", filename).Warnln("Could not delete export of deleted user")
+			}
+		}
+	}
+
+	a.audit(c, "account.delete", "user", strconv.Itoa(userID), &userID, nil)
+
+	a.Log.WithField("user_id", userID).Infoln("Deleted user")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,367 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/teamxiv/growbot-api/internal/config"
+	"github.com/teamxiv/growbot-api/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+// OIDCFlowTimeout is how long a user has to finish logging in with their identity provider
+const OIDCFlowTimeout = time.Minute * 10
+
+// oidcProvider is a configured OpenID Connect provider.
+//
+// Discovery is done lazily, so that a provider being down doesn't stop the API from starting.
+type oidcProvider struct {
+	conf config.OIDCProviderConfig
+
+	mux      sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcFlow is a login that has been started, but not finished
+type oidcFlow struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type oidcProviders struct {
+	providers map[string]*oidcProvider
+
+	flows    map[string]oidcFlow
+	flowsMux sync.Mutex
+}
+
+func newOIDCProviders(confs []config.OIDCProviderConfig) *oidcProviders {
+	p := &oidcProviders{
+		providers: make(map[string]*oidcProvider),
+		flows:     make(map[string]oidcFlow),
+	}
+
+	for _, conf := range confs {
+		p.providers[conf.Name] = &oidcProvider{conf: conf}
+	}
+
+	return p
+}
+
+// discover fetches the provider configuration, if we haven't already
+func (p *oidcProvider) discover(ctx context.Context) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.oauth != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.conf.Issuer)
+	if err != nil {
+		return err
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.conf.ClientID,
+		ClientSecret: p.conf.ClientSecret,
+		RedirectURL:  p.conf.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.conf.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.conf.ClientID})
+
+	return nil
+}
+
+// start remembers a new flow and returns its state
+func (p *oidcProviders) start(flow oidcFlow) (string, error) {
+	state, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	p.flowsMux.Lock()
+	defer p.flowsMux.Unlock()
+
+	// Clean up flows nobody finished
+	now := time.Now()
+	for k, f := range p.flows {
+		if now.After(f.ExpiresAt) {
+			delete(p.flows, k)
+		}
+	}
+
+	p.flows[state] = flow
+	return state, nil
+}
+
+// finish returns and forgets the flow with the given state
+func (p *oidcProviders) finish(state string) (oidcFlow, bool) {
+	p.flowsMux.Lock()
+	defer p.flowsMux.Unlock()
+
+	flow, ok := p.flows[state]
+	if !ok {
+		return flow, false
+	}
+	delete(p.flows, state)
+
+	if time.Now().After(flow.ExpiresAt) {
+		return flow, false
+	}
+
+	return flow, true
+}
+
+// oidcProviderFromParam gets the provider named in the URL, discovering it if necessary
+func (a *API) oidcProviderFromParam(c *gin.Context) (*oidcProvider, bool) {
+	provider, ok := a.oidc.providers[c.Param("provider")]
+	if !ok {
+		a.error(c, http.StatusNotFound, "Unknown identity provider")
+		return nil, false
+	}
+
+	if err := provider.discover(c); err != nil {
+		a.Log.WithError(err).WithField("provider", provider.conf.Name).Warnln("OIDC discovery failed")
+		a.error(c, http.StatusBadGateway, "Could not reach identity provider")
+		return nil, false
+	}
+
+	return provider, true
+}
+
+// AuthOIDCListGet lists the names of the configured identity providers
+func (a *API) AuthOIDCListGet(c *gin.Context) {
+	names := []string{}
+	for _, conf := range a.Config.OIDC {
+		names = append(names, conf.Name)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"providers": names,
+	})
+}
+
+// AuthOIDCStartGet starts a login with an identity provider.
+//
+// Returns the URL the user should be sent to. The provider will send the user
+// back to the configured redirect URL with a code and state, which should then
+// be posted to AuthOIDCCallbackPost.
+func (a *API) AuthOIDCStartGet(c *gin.Context) {
+	provider, ok := a.oidcProviderFromParam(c)
+	if !ok {
+		return
+	}
+
+	nonce, err := randomToken(16)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	flow := oidcFlow{
+		Provider:     provider.conf.Name,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		ExpiresAt:    time.Now().Add(OIDCFlowTimeout),
+	}
+
+	state, err := a.oidc.start(flow)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	url := provider.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(flow.CodeVerifier))
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": url,
+	})
+}
+
+// AuthOIDCCallbackPost takes the "code" and "state" the identity provider sent the user back with.
+//
+// The ID token is validated, the identity is linked to a user (creating one if necessary),
+// and then a GrowBot JWT is returned, just like /auth/login.
+func (a *API) AuthOIDCCallbackPost(c *gin.Context) {
+	provider, ok := a.oidcProviderFromParam(c)
+	if !ok {
+		return
+	}
+
+	input := struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flow, ok := a.oidc.finish(input.State)
+	if !ok || flow.Provider != provider.conf.Name {
+		a.error(c, http.StatusBadRequest, "Login expired, please try again")
+		return
+	}
+
+	token, err := provider.oauth.Exchange(c, input.Code, oauth2.VerifierOption(flow.CodeVerifier))
+	if err != nil {
+		a.error(c, http.StatusUnauthorized, "Could not exchange code: "+err.Error())
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		a.error(c, http.StatusUnauthorized, "Identity provider did not return an ID token")
+		return
+	}
+
+	idToken, err := provider.verifier.Verify(c, rawIDToken)
+	if err != nil {
+		a.error(c, http.StatusUnauthorized, "Invalid ID token: "+err.Error())
+		return
+	}
+
+	if idToken.Nonce != flow.Nonce {
+		a.error(c, http.StatusUnauthorized, "Invalid ID token nonce")
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		a.error(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if claims.Email == "" {
+		a.error(c, http.StatusUnauthorized, "Identity provider did not share an email address")
+		return
+	}
+
+	user, err := a.oidcUser(provider.conf.Name, idToken.Subject, claims)
+	if err == errOIDCEmailUnverified {
+		a.error(c, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !user.Activated {
+		a.error(c, http.StatusUnauthorized, "account not activated")
+		return
+	}
+
+	jwtToken, expire, err := a.authMiddleware.TokenGenerator(user)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.insertAudit(AuditEntry{
+		ActorID:    &user.ID,
+		Credential: AuditCredentialOIDC,
+		IP:         optionalString(c.ClientIP()),
+		Action:     "auth.login",
+		TargetType: optionalString("identity_provider"),
+		TargetID:   optionalString(provider.conf.Name),
+		OwnerID:    &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"token":  jwtToken,
+		"expire": expire.Format(time.RFC3339),
+	})
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+var errOIDCEmailUnverified = errors.New("your email address hasn't been verified by this provider, verify it there first")
+
+// oidcUser finds the user linked to the external identity.
+//
+// If the identity hasn't been seen before, it is linked to the user with the same email address,
+// or a new user is created. Either way the provider has to have verified the email address,
+// otherwise anyone could claim an address here by using it with the provider.
+func (a *API) oidcUser(provider string, subject string, claims oidcClaims) (*models.User, error) {
+	var user models.User
+
+	err := a.DB.Get(&user, "select u.id, u.is_activated, u.role from users as u, user_identities as i where i.provider = $1 and i.subject = $2 and i.user_id = u.id", provider, subject)
+	if err == nil {
+		return &user, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if !claims.EmailVerified {
+		return nil, errOIDCEmailUnverified
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&user, "select id, is_activated, role from users where email = $1", claims.Email)
+	if err == sql.ErrNoRows {
+		// Nobody has this email address, so create a new user.
+		// They don't get a usable password, they can set one later.
+		secret, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		user = models.User{
+			Forename:  claims.GivenName,
+			Surname:   claims.FamilyName,
+			Email:     claims.Email,
+			Password:  string(password),
+			Activated: true,
+			Role:      models.UserRoleUser,
+		}
+
+		err = tx.Get(&user.ID, "insert into users(forename, surname, email, password, is_activated) values ($1, $2, $3, $4, $5) returning id", user.Forename, user.Surname, user.Email, user.Password, user.Activated)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("insert into user_identities(user_id, provider, subject, email) values ($1, $2, $3, $4)", user.ID, provider, subject, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	a.Log.WithField("user_id", user.ID).WithField("provider", provider).Infoln("Linked external identity")
+
+	return &user, nil
+}
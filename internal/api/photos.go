@@ -25,31 +25,34 @@ func (a *API) PhotoCheck(c *gin.Context) {
 
 	photo := struct {
 		models.PlantPhoto
-		UserID int `db:"user_id"`
+		UserID      int  `db:"user_id"`
+		HouseholdID *int `db:"household_id"`
 	}{}
-	err = a.DB.Get(&photo, "select ph.*, pl.user_id as user_id from plant_photos as ph, plants as pl where ph.id = $1 and ph.plant_id = pl.id", id)
+	err = a.DB.Get(&photo, "select ph.*, pl.user_id as user_id, pl.household_id as household_id from plant_photos as ph, plants as pl where ph.id = $1 and ph.plant_id = pl.id", id)
 	if err != nil {
 		BadRequest(c, "Photo does not exist ("+err.Error()+")")
 		c.Abort()
 		return
 	}
 
-	_, loggedIn := c.Get("user_id")
-	if uid := photo.UserID; loggedIn && uid != c.GetInt("user_id") {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "error",
-			"message": "You don't own that plant",
-		})
+	if !a.accessCheck(c, &photo.UserID, photo.HouseholdID, "plant") {
+		c.Abort()
+		return
+	}
+
+	if !a.scopeCheck(c, models.ScopePlantsRead, models.ScopePlantsWrite) {
 		c.Abort()
 		return
 	}
 
 	// Store the robot in the context
 	c.Set("photo", &photo.PlantPhoto)
+	c.Set("photo_owner_id", photo.UserID)
 }
 
 // PhotosListGet requires you to be logged in.
-// It lists all photos of plants the user owns
+// It lists all photos of plants the user owns or that are shared with their households.
+// Photos of archived plants are only listed when asking for the plant's photos with "plant_id".
 func (a *API) PhotosListGet(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -64,13 +67,18 @@ func (a *API) PhotosListGet(c *gin.Context) {
 		}
 	}
 
+	archived, ok := a.archivedFilter(c, "ph")
+	if !ok {
+		return
+	}
+
 	photos := []models.PlantPhoto{}
 
 	var err error
 	if plantIDstr == "" {
-		err = a.DB.Select(&photos, "select ph.* from plants as pl, plant_photos as ph where pl.user_id=$1 and ph.plant_id=pl.id", userID)
+		err = a.DB.Select(&photos, "select ph.* from plants as pl, plant_photos as ph where (pl.user_id=$1 or pl.household_id in "+sqlMyHouseholds+") and ph.plant_id=pl.id and pl.archived_at is null"+archived, userID)
 	} else {
-		err = a.DB.Select(&photos, "select ph.* from plants as pl, plant_photos as ph where pl.user_id=$1 and ph.plant_id=pl.id and pl.id=$2", userID, plantID)
+		err = a.DB.Select(&photos, "select ph.* from plants as pl, plant_photos as ph where (pl.user_id=$1 or pl.household_id in "+sqlMyHouseholds+") and ph.plant_id=pl.id and pl.id=$2"+archived, userID, plantID)
 	}
 
 	if err != nil {
@@ -119,31 +127,21 @@ func (a *API) PhotoServeGet(c *gin.Context) {
 	}
 }
 
-// PhotoDelete deletes the associated photo
+// PhotoDelete archives the associated photo. The file is kept until the retention period is over.
 func (a *API) PhotoDelete(c *gin.Context) {
 	photo := c.MustGet("photo").(*models.PlantPhoto)
 
-	_, err := a.DB.Exec("delete from plant_photos where id=$1", photo.ID)
-	if err != nil {
-		a.error(c, http.StatusInternalServerError, err.Error())
+	ownerID := c.GetInt("photo_owner_id")
+	if !a.ownerCheck(c, &ownerID, "photo") {
 		return
 	}
 
-	key := photoBucketKey(photo.Filename)
-	exists, err := a.Bucket.Exists(c, key)
+	_, err := a.DB.Exec("update plant_photos set archived_at = timezone('utc', now()) where id = $1 and archived_at is null", photo.ID)
 	if err != nil {
 		a.error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if exists {
-		err = a.Bucket.Delete(c, key)
-		if err != nil {
-			a.error(c, http.StatusInternalServerError, err.Error())
-			return
-		}
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
@@ -1,16 +1,20 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/teamxiv/growbot-api/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 // PlantCheck is a middleware to check whether the passed plant uuid exists,
-// and (if logged in) confirms whether the currently logged in user owns that plant
+// and (if logged in) confirms whether the currently logged in user owns that plant,
+// or is a member of the household it is shared with
 func (a *API) PlantCheck(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -28,12 +32,12 @@ func (a *API) PlantCheck(c *gin.Context) {
 		return
 	}
 
-	_, loggedIn := c.Get("user_id")
-	if uid := plant.UserID; loggedIn && uid != c.GetInt("user_id") {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "error",
-			"message": "you don't own that plant",
-		})
+	if !a.accessCheck(c, &plant.UserID, plant.HouseholdID, "plant") {
+		c.Abort()
+		return
+	}
+
+	if !a.scopeCheck(c, models.ScopePlantsRead, models.ScopePlantsWrite) {
 		c.Abort()
 		return
 	}
@@ -42,47 +46,229 @@ func (a *API) PlantCheck(c *gin.Context) {
 	c.Set("plant", &plant)
 }
 
+// PlantActiveCheck is a middleware that turns away changes to archived plants, which have to be restored first.
+// Use after PlantCheck.
+func (a *API) PlantActiveCheck(c *gin.Context) {
+	if c.MustGet("plant").(*models.Plant).ArchivedAt != nil {
+		a.error(c, http.StatusConflict, "Plant is archived, restore it first")
+		c.Abort()
+	}
+}
+
+// sqlMoistureStatus works out the moisture status of plant p with care profile c, like models.CareProfile.MoistureStatus
+const sqlMoistureStatus = `(case
+	when c.id is null or p.soil_moisture is null then 'unknown'
+	when p.soil_moisture < c.min_moisture then 'dry'
+	when p.soil_moisture > c.max_moisture then 'wet'
+	else 'ok'
+end)`
+
+// likeEscaper escapes the characters with a special meaning in LIKE patterns, using the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes s match itself literally in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 // PlantListGet requires you to be logged in.
-// It lists all plants the user owns
+// It lists all plants the user owns or that are shared with their households.
+//
+// Plants can be searched by name and custom field values with "q", and filtered by any number of
+// "tag"s and "field"s (either "key" to have the field, or "key=value"), by "moisture" status, by "zone_id",
+// to those with a health score below "health_below", and by whether they are "archived" (include or only).
+//
+// With "group=zone", plants are returned grouped by zone instead, with plants without a zone last.
 func (a *API) PlantListGet(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	plants := []models.Plant{}
+	input := struct {
+		Query    string   `form:"q"`
+		Tags     []string `form:"tag"`
+		Fields   []string `form:"field"`
+		Moisture string   `form:"moisture"`
+		ZoneID   *int     `form:"zone_id"`
+		Health   *int     `form:"health_below"`
+		Group    string   `form:"group"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	archived, ok := a.archivedFilter(c, "p")
+	if !ok {
+		return
+	}
+
+	query := "(p.user_id=$1 or p.household_id in " + sqlMyHouseholds + ")" + archived
+	args := []interface{}{userID}
+
+	if input.Query != "" {
+		args = append(args, "%"+escapeLike(input.Query)+"%")
+		query += fmt.Sprintf(" and (p.name ilike $%d or exists (select 1 from jsonb_each_text(p.fields) as f where f.value ilike $%d))", len(args), len(args))
+	}
+
+	if len(input.Tags) > 0 {
+		args = append(args, pq.Array(normaliseTags(input.Tags)))
+		query += fmt.Sprintf(" and p.tags @> $%d", len(args))
+	}
 
-	err := a.DB.Select(&plants, "select * from plants where user_id=$1", userID)
+	for _, field := range input.Fields {
+		kv := strings.SplitN(field, "=", 2)
+		args = append(args, kv[0])
+		if len(kv) == 1 {
+			query += fmt.Sprintf(" and p.fields ->> $%d is not null", len(args))
+			continue
+		}
+
+		args = append(args, kv[1])
+		query += fmt.Sprintf(" and p.fields ->> $%d = $%d", len(args)-1, len(args))
+	}
+
+	if input.Moisture != "" {
+		if input.Moisture != models.MoistureUnknown && input.Moisture != models.MoistureDry && input.Moisture != models.MoistureOK && input.Moisture != models.MoistureWet {
+			a.error(c, http.StatusBadRequest, "moisture must be one of unknown, dry, ok or wet")
+			return
+		}
+
+		args = append(args, input.Moisture)
+		query += fmt.Sprintf(" and %s = $%d", sqlMoistureStatus, len(args))
+	}
+
+	if input.ZoneID != nil {
+		args = append(args, *input.ZoneID)
+		query += fmt.Sprintf(" and p.zone_id = $%d", len(args))
+	}
+
+	if input.Health != nil {
+		args = append(args, *input.Health)
+		query += fmt.Sprintf(" and p.health < $%d", len(args))
+	}
+
+	if input.Group != "" && input.Group != "zone" {
+		a.error(c, http.StatusBadRequest, "Plants can only be grouped by zone")
+		return
+	}
+
+	type listedPlant struct {
+		models.Plant
+		MoistureStatus string `json:"moisture_status" db:"moisture_status"`
+	}
+
+	plants := []listedPlant{}
+
+	err := a.DB.Select(&plants, "select p.*, "+sqlMoistureStatus+" as moisture_status from plants as p left join care_profiles as c on c.id = p.care_profile_id where "+query+" order by p.id", args...)
 	if err != nil {
 		BadRequest(c, err.Error())
 		return
 	}
 
+	if input.Group == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"plants": plants,
+		})
+		return
+	}
+
+	zoneIDs := []int64{}
+	for _, plant := range plants {
+		if plant.ZoneID != nil {
+			zoneIDs = append(zoneIDs, int64(*plant.ZoneID))
+		}
+	}
+
+	zones := []models.Zone{}
+	err = a.DB.Select(&zones, "select * from zones where id = any($1) order by name", pq.Array(zoneIDs))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type group struct {
+		Zone   *models.Zone  `json:"zone"`
+		Plants []listedPlant `json:"plants"`
+	}
+
+	groups := make([]group, len(zones)+1)
+	index := map[int]int{}
+	for i := range zones {
+		groups[i] = group{&zones[i], []listedPlant{}}
+		index[zones[i].ID] = i
+	}
+	groups[len(zones)].Plants = []listedPlant{}
+
+	for _, plant := range plants {
+		i := len(zones)
+		if plant.ZoneID != nil {
+			i = index[*plant.ZoneID]
+		}
+		groups[i].Plants = append(groups[i].Plants, plant)
+	}
+
+	// Leave out the group without a zone if all plants have one
+	if len(groups[len(zones)].Plants) == 0 {
+		groups = groups[:len(zones)]
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"plants": plants,
+		"groups": groups,
 	})
 }
 
-// PlantGet gets the plant object
+// PlantGet gets the plant object (including its health), along with its care profile and whether its soil is too dry or wet
 func (a *API) PlantGet(c *gin.Context) {
 	plant := c.MustGet("plant").(*models.Plant)
-	c.JSON(http.StatusOK, plant)
+
+	result := struct {
+		models.Plant
+		CareProfile    *models.CareProfile `json:"care_profile"`
+		MoistureStatus string              `json:"moisture_status"`
+	}{Plant: *plant}
+
+	if plant.CareProfileID != nil {
+		var profile models.CareProfile
+		if err := a.DB.Get(&profile, "select * from care_profiles where id = $1", *plant.CareProfileID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		result.CareProfile = &profile
+	}
+
+	result.MoistureStatus = result.CareProfile.MoistureStatus(plant.SoilMoisture)
+
+	c.JSON(http.StatusOK, result)
 }
 
-// PlantDelete deletes the plant object
+// PlantDelete archives the plant object. It can be restored until the retention period is over.
+// Its actions stop being sent to robots in the meantime.
 func (a *API) PlantDelete(c *gin.Context) {
 	plant := c.MustGet("plant").(*models.Plant)
 
-	_, err := a.DB.Exec("delete from plants where id = $1", plant.ID)
+	if !a.ownerCheck(c, &plant.UserID, "plant") {
+		return
+	}
+
+	_, err := a.DB.Exec("update plants set archived_at = timezone('utc', now()) where id = $1 and archived_at is null", plant.ID)
 	if err != nil {
 		a.error(c, http.StatusInternalServerError, "could not delete plant: "+err.Error())
 		return
 	}
 
+	if plant.ZoneID != nil || plant.MarkerID != nil {
+		a.pingPlantMaps(plant.UserID, plant.HouseholdID)
+	}
+	a.pingPlantRobots(plant.ID)
+
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
-// PlantCreatePost gets the plant object
+// PlantCreatePost creates a plant. Takes a "name", and optionally a "care_profile_id".
 func (a *API) PlantCreatePost(c *gin.Context) {
 	input := struct {
-		Name string `json:"name"`
+		Name          string `json:"name"`
+		CareProfileID *int   `json:"care_profile_id"`
 	}{}
 
 	err := c.BindJSON(&input)
@@ -92,11 +278,19 @@ func (a *API) PlantCreatePost(c *gin.Context) {
 	}
 
 	row := models.Plant{
-		Name:   input.Name,
-		UserID: c.GetInt("user_id"),
+		Name:          input.Name,
+		UserID:        c.GetInt("user_id"),
+		CareProfileID: input.CareProfileID,
+	}
+
+	if input.CareProfileID != nil {
+		if _, err := a.careProfileFor(*input.CareProfileID, row.UserID); err != nil {
+			a.error(c, http.StatusBadRequest, "Care profile does not exist")
+			return
+		}
 	}
 
-	rows, err := a.DB.NamedQuery("insert into plants(name, user_id) values (:name, :user_id) returning id", row)
+	rows, err := a.DB.NamedQuery("insert into plants(name, user_id, care_profile_id) values (:name, :user_id, :care_profile_id) returning id", row)
 	defer rows.Close()
 	if err != nil {
 		a.error(c, http.StatusInternalServerError, err.Error())
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teamxiv/growbot-api/internal/config"
+)
+
+// RateLimitWindow is the window requests per IP address are counted in
+const RateLimitWindow = time.Minute
+
+// RateLimitFailureMemory is how long failures are remembered after the last one, so that lockouts keep growing
+// for someone who waits for each one to end
+const RateLimitFailureMemory = time.Hour * 24
+
+// RateLimitMaxEntries is the most keys tracked. Past it, keys that aren't locked out are forgotten early.
+const RateLimitMaxEntries = 100000
+
+// limitEntry tracks a single key, e.g. "ip:127.0.0.1" or "account:foo@example.com" (see accountLimitKey)
+type limitEntry struct {
+	// Requests made in the current window
+	requests    int
+	windowStart time.Time
+
+	// Consecutive failures, when the last one was, and when the lockout caused by them ends
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type rateLimiter struct {
+	conf config.RateLimitConfig
+
+	m   map[string]*limitEntry
+	mux sync.Mutex
+}
+
+// accountLimitKey is the key failed passwords for the account with the email address are counted under,
+// whether they were when logging in or when confirming the password later on
+func accountLimitKey(email string) string {
+	return "account:" + email
+}
+
+func newRateLimiter(conf config.RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		conf: conf,
+		m:    make(map[string]*limitEntry),
+	}
+}
+
+// entry returns the entry for the key, creating it if necessary. The mutex must be held.
+func (l *rateLimiter) entry(key string, now time.Time) *limitEntry {
+	e, ok := l.m[key]
+	if !ok {
+		e = &limitEntry{windowStart: now}
+		l.m[key] = e
+	}
+	return e
+}
+
+// request counts a request made with the key, and returns how long to wait if too many have been made
+func (l *rateLimiter) request(key string) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := time.Now()
+	e := l.entry(key, now)
+
+	if now.Sub(e.windowStart) >= RateLimitWindow {
+		e.requests = 0
+		e.windowStart = now
+	}
+
+	e.requests++
+	if e.requests > l.conf.PerIP {
+		return e.windowStart.Add(RateLimitWindow).Sub(now)
+	}
+
+	return 0
+}
+
+// locked returns how long the key is locked out for
+func (l *rateLimiter) locked(key string) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	e, ok := l.m[key]
+	if !ok {
+		return 0
+	}
+
+	if wait := time.Until(e.lockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fail records a failure for the key, and returns how long it is now locked out for.
+//
+// After MaxFailures consecutive failures the key is locked out, and every failure
+// after that doubles the lockout (up to MaxLockout).
+func (l *rateLimiter) fail(key string) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := time.Now()
+	e := l.entry(key, now)
+	e.failures++
+	e.lastFailure = now
+
+	over := e.failures - l.conf.MaxFailures
+	if over < 0 {
+		return 0
+	}
+
+	lockout := time.Duration(float64(l.conf.Lockout) * math.Pow(2, float64(over)))
+	if lockout > l.conf.MaxLockout || lockout <= 0 {
+		lockout = l.conf.MaxLockout
+	}
+
+	e.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// succeed forgets the failures of the key
+func (l *rateLimiter) succeed(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if e, ok := l.m[key]; ok {
+		e.failures = 0
+		e.lockedUntil = time.Time{}
+	}
+}
+
+// cleanup forgets keys that are neither locked out nor in a current window, and haven't failed within
+// RateLimitFailureMemory. If there are still more than RateLimitMaxEntries, keys that aren't locked out are
+// forgotten until there aren't, so that trying lots of different accounts can't fill up the map.
+func (l *rateLimiter) cleanup() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := time.Now()
+	for key, e := range l.m {
+		if now.After(e.lockedUntil) && now.Sub(e.windowStart) >= RateLimitWindow && (e.failures == 0 || now.Sub(e.lastFailure) >= RateLimitFailureMemory) {
+			delete(l.m, key)
+		}
+	}
+
+	for key, e := range l.m {
+		if len(l.m) <= RateLimitMaxEntries {
+			break
+		}
+		if now.After(e.lockedUntil) {
+			delete(l.m, key)
+		}
+	}
+}
+
+// tooManyRequests responds with 429 and a Retry-After header
+func (a *API) tooManyRequests(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	a.error(c, http.StatusTooManyRequests, "Too many attempts, please try again later")
+}
+
+// RateLimit returns a middleware that limits how often an IP address can call the endpoint,
+// and turns away IP addresses that have been locked out after failing to log in.
+func (a *API) RateLimit(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+
+		wait := a.limiter.locked("ip:" + ip)
+		if wait == 0 {
+			wait = a.limiter.request(name + ":" + ip)
+		}
+
+		if wait > 0 {
+			a.tooManyRequests(c, wait)
+			c.Abort()
+		}
+	}
+}
+
+// cleanupLimiter periodically forgets stale rate limiter entries
+func (a *API) cleanupLimiter() {
+	for range time.Tick(RateLimitWindow) {
+		a.limiter.cleanup()
+	}
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"net/http"
 
-	"github.com/gorilla/websocket"
 	"github.com/teamxiv/growbot-api/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -11,7 +10,8 @@ import (
 )
 
 // RobotCheck is a middleware to check whether the passed robot uuid exists,
-// and (if logged in) confirms whether the currently logged in user owns that robot
+// and (if logged in) confirms whether the currently logged in user owns that robot,
+// or is a member of the household it is shared with
 func (a *API) RobotCheck(c *gin.Context) {
 	id := c.Param("uuid")
 	rid, err := uuid.Parse(id)
@@ -29,12 +29,12 @@ func (a *API) RobotCheck(c *gin.Context) {
 		return
 	}
 
-	_, loggedIn := c.Get("user_id")
-	if uid := robot.UserID; loggedIn && (uid == nil || *uid != c.GetInt("user_id")) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  "error",
-			"message": "you don't own that robot",
-		})
+	if !a.accessCheck(c, robot.UserID, robot.HouseholdID, "robot") {
+		c.Abort()
+		return
+	}
+
+	if !a.scopeCheck(c, models.ScopeRobotsRead, models.ScopeRobotsControl) {
 		c.Abort()
 		return
 	}
@@ -52,7 +52,7 @@ func (a *API) RobotVideoGet(c *gin.Context) {
 }
 
 // RobotListGet requires you to be logged in.
-// It lists all robots the user owns + the robot state.
+// It lists all robots the user owns or that are shared with their households + the robot state.
 func (a *API) RobotListGet(c *gin.Context) {
 	user_id := c.GetInt("user_id")
 
@@ -62,7 +62,7 @@ func (a *API) RobotListGet(c *gin.Context) {
 		models.RobotState
 	}{}
 
-	err := a.DB.Select(&robots, "select robots.id as robot_id,created_at,updated_at,robot_state.*,title from robots,robot_state where robots.user_id=$1 and robot_state.id=robots.id", user_id)
+	err := a.DB.Select(&robots, "select robots.id as robot_id,user_id,household_id,created_at,updated_at,robot_state.*,title from robots,robot_state where (robots.user_id=$1 or robots.household_id in "+sqlMyHouseholds+") and robot_state.id=robots.id", user_id)
 	if err != nil {
 		BadRequest(c, err.Error())
 		return
@@ -105,7 +105,17 @@ func (a *API) RobotRegisterPost(c *gin.Context) {
 	}
 
 	if robot.UserID != nil {
-		BadRequest(c, "This robot has already been registered. Please email us.")
+		BadRequest(c, "This robot has already been registered. Ask its owner to transfer it to you, or email us.")
+		return
+	}
+
+	var resetting bool
+	err = a.DB.Get(&resetting, "select exists(select 1 from robot_decommissions where robot_id = $1 and completed_at is null)", robot.ID)
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	} else if resetting {
+		BadRequest(c, "This robot is waiting to be reset. Turn it on, and try again once it has restarted.")
 		return
 	}
 
@@ -148,7 +158,11 @@ func (a *API) RobotStatusGet(c *gin.Context) {
 func (a *API) RobotDelete(c *gin.Context) {
 	robot := c.MustGet("robot").(*models.Robot)
 
-	_, err := a.DB.Exec("update robots set user_id=null,title='' where id=$1", robot.ID)
+	if !a.ownerCheck(c, robot.UserID, "robot") {
+		return
+	}
+
+	_, err := a.DB.Exec("with t as (delete from robot_transfers where robot_id=$1), r as (delete from watering_rules where robot_id=$1) update robots set user_id=null,title='',household_id=null where id=$1", robot.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "could not delete row: " + err.Error(),
@@ -156,6 +170,8 @@ func (a *API) RobotDelete(c *gin.Context) {
 		return
 	}
 
+	a.audit(c, "robot.unregister", "robot", robot.ID.String(), robot.UserID, nil)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 	})
@@ -181,19 +197,14 @@ func (a *API) RobotMovePost(c *gin.Context) {
 		Data: result.Direction,
 	}
 
-	robotCtxsMutex.Lock()
-	wctx, ok := robotCtxs[robot.ID]
-	robotCtxsMutex.Unlock()
-
-	if !ok {
+	if sent, _ := writeRobot(robot.ID, payload); !sent {
 		c.JSON(http.StatusFailedDependency, gin.H{
 			"message": "Robot not connected",
 		})
 		return
 	}
 
-	wsc := wctx.MustGet("ws").(*websocket.Conn)
-	wsc.WriteJSON(payload)
+	a.audit(c, "robot.move", "robot", robot.ID.String(), robot.UserID, payload)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -230,14 +241,9 @@ func (a *API) RobotSetStandby(c *gin.Context) {
 
 	payload := payloadSetStandby(input.Standby)
 
-	robotCtxsMutex.Lock()
-	wctx, ok := robotCtxs[robot.ID]
-	robotCtxsMutex.Unlock()
+	writeRobot(robot.ID, payload)
 
-	if ok {
-		wsc := wctx.MustGet("ws").(*websocket.Conn)
-		wsc.WriteJSON(payload)
-	}
+	a.audit(c, "robot.standby", "robot", robot.ID.String(), robot.UserID, payload)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -264,19 +270,14 @@ func (a *API) RobotStartDemoPost(c *gin.Context) {
 		Data: result.Procedure,
 	}
 
-	robotCtxsMutex.Lock()
-	wctx, ok := robotCtxs[robot.ID]
-	robotCtxsMutex.Unlock()
-
-	if !ok {
+	if sent, _ := writeRobot(robot.ID, payload); !sent {
 		c.JSON(http.StatusFailedDependency, gin.H{
 			"message": "Robot not connected",
 		})
 		return
 	}
 
-	wsc := wctx.MustGet("ws").(*websocket.Conn)
-	wsc.WriteJSON(payload)
+	a.audit(c, "robot.demo", "robot", robot.ID.String(), robot.UserID, payload)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -329,20 +330,13 @@ func (a *API) RobotSettingsPatch(c *gin.Context) {
 		},
 	}
 
-	robotCtxsMutex.Lock()
-	wctx, ok := robotCtxs[robot.ID]
-	robotCtxsMutex.Unlock()
-
-	if !ok {
+	if sent, _ := writeRobot(robot.ID, payload); !sent {
 		c.JSON(http.StatusFailedDependency, gin.H{
 			"message": "Robot not connected",
 		})
 		return
 	}
 
-	wsc := wctx.MustGet("ws").(*websocket.Conn)
-	wsc.WriteJSON(payload)
-
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
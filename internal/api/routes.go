@@ -0,0 +1,598 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// RouteMaxSteps is the most steps a route can have
+const RouteMaxSteps = 50
+
+// validateSteps checks the steps of a route, and that the user can use all of its plants.
+// Returns a message if they're invalid.
+func (a *API) validateSteps(userID int, steps []models.RouteStep) (string, error) {
+	if len(steps) == 0 || len(steps) > RouteMaxSteps {
+		return "Routes must have between 1 and " + strconv.Itoa(RouteMaxSteps) + " steps", nil
+	}
+
+	for i, step := range steps {
+		n := strconv.Itoa(i + 1)
+
+		switch step.Action {
+		case models.RouteStepVisitPlant, models.RouteStepPhoto, models.RouteStepWater:
+			if step.PlantID == nil {
+				return "Step " + n + " needs a plant_id", nil
+			}
+
+			if ok, err := a.canUsePlant(userID, *step.PlantID); err != nil {
+				return "", err
+			} else if !ok {
+				return "Step " + n + " is for a plant you can't use", nil
+			}
+
+		case models.RouteStepReturnToDock:
+			if step.PlantID != nil || step.If != nil {
+				return "Step " + n + " can't have a plant or a condition", nil
+			}
+
+		default:
+			return "Step " + n + " has an unknown action " + step.Action, nil
+		}
+
+		if cond := step.If; cond != nil {
+			if cond.MoistureBelow != nil && (*cond.MoistureBelow <= 0 || *cond.MoistureBelow > 100) {
+				return "Step " + n + ": moisture_below must be between 1 and 100", nil
+			}
+
+			switch cond.MoistureStatus {
+			case "", models.MoistureDry, models.MoistureOK, models.MoistureWet:
+			default:
+				return "Step " + n + ": moisture_status must be one of dry, ok or wet", nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// routeFor gets a route the robot can run: one of its owner's, or one shared with its household.
+// Returns sql.ErrNoRows otherwise.
+func (a *API) routeFor(routeID int, robot *models.Robot) (*models.Route, error) {
+	var route models.Route
+	err := a.DB.Get(&route, "select * from routes where id = $1 and (user_id = $2 or household_id = $3)", routeID, robot.UserID, robot.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// canUseRoute returns whether the user can set up events running the route
+func (a *API) canUseRoute(userID int, routeID int) (bool, error) {
+	var ok bool
+	err := a.DB.Get(&ok, "select exists(select 1 from routes where id = $1 and "+sqlCanChange+")", routeID, userID)
+	return ok, err
+}
+
+// routeActionID returns the route of a RUN_ROUTE action
+func routeActionID(action *models.EventAction) (int, bool) {
+	data := struct {
+		RouteID *int `json:"route_id"`
+	}{}
+
+	if err := action.Data.Unmarshal(&data); err != nil || data.RouteID == nil {
+		return 0, false
+	}
+	return *data.RouteID, true
+}
+
+// routePlan turns the steps of the route into what the robot is sent.
+//
+// Steps for plants the robot can't serve are left out, as routes shared with a household can include plants
+// that are private to whoever set them up. So are steps with a moisture_status condition for plants without a
+// care profile, as there is no range to judge it against. Watering steps are left out while the robot is holding
+// back watering, as with PLANT_WATER actions.
+func (a *API) routePlan(route *models.Route, robot *models.Robot) ([]models.RoutePlanStep, error) {
+	steps := []models.RouteStep{}
+	if err := route.Steps.Unmarshal(&steps); err != nil {
+		return nil, err
+	}
+
+	paused, err := a.isWateringPaused(robot.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []models.RoutePlanStep{}
+	for i, step := range steps {
+		if paused && step.Action == models.RouteStepWater {
+			continue
+		}
+
+		planStep := models.RoutePlanStep{Step: i, RouteStep: step}
+
+		if step.PlantID != nil {
+			plant := models.Plant{}
+			err := a.DB.Get(&plant, "select * from plants where id = $1 and archived_at is null", *step.PlantID)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			if !robotCanServe(robot, &plant) {
+				continue
+			}
+
+			if step.If != nil && step.If.MoistureStatus != "" {
+				if plant.CareProfileID == nil {
+					continue
+				}
+
+				profile := models.CareProfile{}
+				if err := a.DB.Get(&profile, "select * from care_profiles where id = $1", *plant.CareProfileID); err != nil {
+					return nil, err
+				}
+				planStep.MinMoisture = &profile.MinMoisture
+				planStep.MaxMoisture = &profile.MaxMoisture
+			}
+		}
+
+		plan = append(plan, planStep)
+	}
+
+	return plan, nil
+}
+
+// expandRouteAction puts the plan of the route into a RUN_ROUTE action, so that the robot gets all of it
+func (a *API) expandRouteAction(action *models.EventAction) error {
+	routeID, ok := routeActionID(action)
+	if !ok {
+		return fmt.Errorf("RUN_ROUTE action %d has no route_id", action.ID)
+	}
+
+	robot := models.Robot{}
+	if err := a.DB.Get(&robot, "select * from robots where id = $1", action.RobotID); err != nil {
+		return err
+	}
+
+	route, err := a.routeFor(routeID, &robot)
+	if err != nil {
+		return err
+	}
+
+	plan, err := a.routePlan(route, &robot)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(gin.H{
+		"route_id": route.ID,
+		"name":     route.Name,
+		"steps":    plan,
+	})
+	if err != nil {
+		return err
+	}
+
+	action.Data = types.JSONText(data)
+	return nil
+}
+
+// RouteCheck is a middleware to check whether the passed route exists,
+// and that the currently logged in user owns it, or is a member of the household it is shared with
+func (a *API) RouteCheck(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		c.Abort()
+		return
+	}
+
+	route := models.Route{}
+	err = a.DB.Get(&route, "select * from routes where id = $1", id)
+	if err != nil {
+		BadRequest(c, "Route does not exist ("+err.Error()+")")
+		c.Abort()
+		return
+	}
+
+	if !a.accessCheck(c, &route.UserID, route.HouseholdID, "route") {
+		c.Abort()
+		return
+	}
+
+	c.Set("route", &route)
+}
+
+// RouteListGet lists the routes the user owns or that are shared with their households
+func (a *API) RouteListGet(c *gin.Context) {
+	routes := []models.Route{}
+
+	err := a.DB.Select(&routes, "select * from routes where user_id = $1 or household_id in "+sqlMyHouseholds+" order by name", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+	})
+}
+
+// routeInput binds and validates a route from the request. Responds and returns false if it's invalid.
+func (a *API) routeInput(c *gin.Context) (string, types.JSONText, bool) {
+	input := struct {
+		Name  string             `json:"name"`
+		Steps []models.RouteStep `json:"steps"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return "", nil, false
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Routes must have a name")
+		return "", nil, false
+	}
+
+	if msg, err := a.validateSteps(c.GetInt("user_id"), input.Steps); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return "", nil, false
+	} else if msg != "" {
+		a.error(c, http.StatusBadRequest, msg)
+		return "", nil, false
+	}
+
+	steps, err := json.Marshal(input.Steps)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return "", nil, false
+	}
+
+	return input.Name, types.JSONText(steps), true
+}
+
+// RouteCreatePost creates a route. Takes a "name" and the "steps", each with an "action", a "plant_id"
+// (except when returning to the dock), optionally the "data" of the action, and an "if" condition
+// with a "moisture_below" and/or "moisture_status".
+func (a *API) RouteCreatePost(c *gin.Context) {
+	name, steps, ok := a.routeInput(c)
+	if !ok {
+		return
+	}
+
+	var id int
+	err := a.DB.Get(&id, "insert into routes(user_id, name, steps) values ($1, $2, $3) returning id", c.GetInt("user_id"), name, steps)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// RouteGet gets the route
+func (a *API) RouteGet(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+	c.JSON(http.StatusOK, route)
+}
+
+// RoutePut replaces the route. Takes the same as RouteCreatePost.
+// Robots with events running the route are sent the new plan.
+func (a *API) RoutePut(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+
+	name, steps, ok := a.routeInput(c)
+	if !ok {
+		return
+	}
+
+	_, err := a.DB.Exec("update routes set name = $2, steps = $3, updated_at = timezone('utc', now()) where id = $1", route.ID, name, steps)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rids := []uuid.UUID{}
+	err = a.DB.Select(&rids, "select distinct robot_id from event_actions where name = $1 and (data->>'route_id')::integer = $2", models.EventActionRunRoute, route.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, rid := range rids {
+		a.pingRobotEvents(rid, true)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RouteDelete deletes the route, along with the event actions running it
+func (a *API) RouteDelete(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+
+	if !a.ownerCheck(c, &route.UserID, "route") {
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	rids := []uuid.UUID{}
+	err = tx.Select(&rids, "delete from event_actions where name = $1 and (data->>'route_id')::integer = $2 returning robot_id", models.EventActionRunRoute, route.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := tx.Exec("delete from routes where id = $1", route.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, rid := range rids {
+		a.pingRobotEvents(rid, true)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RouteHouseholdPut shares the route with a household. Takes a "household_id", which can be null to stop sharing it.
+func (a *API) RouteHouseholdPut(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+	a.setHousehold(c, "routes", route.ID, &route.UserID)
+}
+
+// RouteRunPost runs the route straight away. Takes the "robot_id" of the robot to run it.
+func (a *API) RouteRunPost(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		RobotID uuid.UUID `json:"robot_id"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if ok, err := a.canUseRobot(userID, input.RobotID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if !ok {
+		a.error(c, http.StatusBadRequest, "Robot does not exist")
+		return
+	}
+
+	robot := models.Robot{}
+	if err := a.DB.Get(&robot, "select * from robots where id = $1", input.RobotID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := a.routeFor(route.ID, &robot); err != nil {
+		a.error(c, http.StatusBadRequest, "That robot can't run this route, share it with the robot's household first")
+		return
+	}
+
+	if !isRobotConnected(robot.ID) {
+		a.error(c, http.StatusFailedDependency, "Robot not connected")
+		return
+	}
+
+	data, _ := json.Marshal(gin.H{"route_id": route.ID})
+	action := models.EventAction{
+		Name:    models.EventActionRunRoute,
+		Data:    types.JSONText(data),
+		RobotID: robot.ID,
+	}
+
+	if err := a.runNow(userID, "Route: "+route.Name, action); dispatchReason(err) != "" {
+		a.error(c, http.StatusConflict, "Can't run the route now, "+err.Error())
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+	})
+}
+
+// RouteRunListGet lists the runs of the route, newest first
+func (a *API) RouteRunListGet(c *gin.Context) {
+	route := c.MustGet("route").(*models.Route)
+
+	runs := []models.RouteRun{}
+	if err := a.DB.Select(&runs, "select * from route_runs where route_id = $1 order by started_at desc limit 50", route.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs": runs,
+	})
+}
+
+// transmitRouteRun lets the users who can see the route know how the run is going
+func (a *API) transmitRouteRun(run *models.RouteRun) {
+	route := models.Route{}
+	if err := a.DB.Get(&route, "select user_id, household_id from routes where id = $1", run.RouteID); err != nil {
+		a.Log.WithError(err).WithField("run_id", run.ID).Warnln("Could not get route for ROUTE_PROGRESS")
+		return
+	}
+
+	a.transmitShared(route.UserID, route.HouseholdID, "ROUTE_PROGRESS", run)
+}
+
+// streamRobotRouteStarted is sent by the robot when it starts a route, with the "route_id" and the "event_id" it came from.
+// The robot is sent ROUTE_RUN with the "run_id" to report progress with, or with the reason it was "refused"
+// if it has to stay docked.
+func (a *API) streamRobotRouteStarted(data map[string]interface{}, robot *models.Robot) {
+	routeID, _ := data["route_id"].(float64)
+
+	var eventID *int
+	if v, ok := data["event_id"].(float64); ok {
+		id := int(v)
+		eventID = &id
+	}
+
+	if _, err := a.routeFor(int(routeID), robot); err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).WithField("route_id", int(routeID)).Warnln("Unknown route for ROUTE_STARTED")
+		return
+	}
+
+	// Scheduled routes don't start while the robot has to stay docked
+	if err := a.canDispatch(robot.ID); err != nil {
+		reason := dispatchReason(err)
+		if reason == "" {
+			a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not check docking for ROUTE_STARTED")
+			reason = "error"
+		}
+
+		a.sendRobotMessage(robot.ID, "ROUTE_RUN", gin.H{"route_id": int(routeID), "refused": reason})
+		return
+	}
+
+	var run models.RouteRun
+	err := a.DB.Get(&run, "insert into route_runs(route_id, robot_id, event_id) values ($1, $2, $3) returning *", int(routeID), robot.ID, eventID)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not insert route run for ROUTE_STARTED")
+		return
+	}
+
+	a.sendRobotMessage(robot.ID, "ROUTE_RUN", gin.H{"route_id": run.RouteID, "run_id": run.ID})
+	a.transmitRouteRun(&run)
+}
+
+// streamRobotRouteProgress is sent by the robot after each step of a route, with the "run_id",
+// the "step" (as numbered in the plan it was sent), its "status" (done, skipped or failed) and optionally a "message"
+func (a *API) streamRobotRouteProgress(data map[string]interface{}, robot *models.Robot) {
+	runID, _ := data["run_id"].(float64)
+	step, _ := data["step"].(float64)
+	status, _ := data["status"].(string)
+	message, _ := data["message"].(string)
+
+	if status != models.RouteStepDone && status != models.RouteStepSkipped && status != models.RouteStepFailed {
+		a.Log.WithField("rid", robot.ID).WithField("status", status).Warnln("Unknown status for ROUTE_PROGRESS")
+		return
+	}
+
+	progress, err := json.Marshal([]models.RouteStepProgress{{
+		Step:    int(step),
+		Status:  status,
+		Message: message,
+		At:      time.Now().UTC(),
+	}})
+	if err != nil {
+		return
+	}
+
+	var run models.RouteRun
+	err = a.DB.Get(
+		&run,
+		"update route_runs set progress = progress || $3, current_step = $4 where id = $1 and robot_id = $2 and status = 'running' returning *",
+		int(runID), robot.ID, types.JSONText(progress), int(step)+1,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).WithField("run_id", int(runID)).Warnln("Could not update route run for ROUTE_PROGRESS")
+		return
+	}
+
+	a.transmitRouteRun(&run)
+}
+
+// streamRobotRouteFinished is sent by the robot at the end of a route, with the "run_id" and its "status"
+// (completed, failed or aborted). Routes that didn't complete are logged.
+func (a *API) streamRobotRouteFinished(data map[string]interface{}, robot *models.Robot) {
+	runID, _ := data["run_id"].(float64)
+	status, _ := data["status"].(string)
+
+	if status != models.RouteRunCompleted && status != models.RouteRunFailed && status != models.RouteRunAborted {
+		a.Log.WithField("rid", robot.ID).WithField("status", status).Warnln("Unknown status for ROUTE_FINISHED")
+		return
+	}
+
+	var run models.RouteRun
+	err := a.DB.Get(
+		&run,
+		"update route_runs set status = $3, finished_at = timezone('utc', now()) where id = $1 and robot_id = $2 and status = 'running' returning *",
+		int(runID), robot.ID, status,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).WithField("run_id", int(runID)).Warnln("Could not finish route run for ROUTE_FINISHED")
+		return
+	}
+
+	a.transmitRouteRun(&run)
+
+	if status != models.RouteRunCompleted {
+		a.logRouteRun(&run, fmt.Sprintf("Route %s after %d steps", status, run.CurrentStep))
+	}
+}
+
+// abortRouteRuns marks the runs the robot was going through as aborted, when it disconnects or changes hands.
+// The reason is added to the log message.
+func (a *API) abortRouteRuns(rid uuid.UUID, reason string) {
+	runs := []models.RouteRun{}
+	err := a.DB.Select(&runs, "update route_runs set status = 'aborted', finished_at = timezone('utc', now()) where robot_id = $1 and status = 'running' returning *", rid)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not abort route runs")
+		return
+	}
+
+	for i := range runs {
+		a.transmitRouteRun(&runs[i])
+		a.logRouteRun(&runs[i], fmt.Sprintf("Route aborted after %d steps, %s", runs[i].CurrentStep, reason))
+	}
+}
+
+func (a *API) logRouteRun(run *models.RouteRun, message string) {
+	route := models.Route{}
+	if err := a.DB.Get(&route, "select name, user_id, household_id from routes where id = $1", run.RouteID); err != nil {
+		a.Log.WithError(err).WithField("run_id", run.ID).Warnln("Could not get route to log")
+		return
+	}
+
+	entry := LogEntry{
+		UserID:   route.UserID,
+		Type:     "route",
+		Message:  "\"" + route.Name + "\": " + message,
+		Severity: LogSeverityWarning,
+		RobotID:  &run.RobotID,
+	}
+
+	if err := a.insertLogEntry(&entry); err != nil {
+		a.Log.WithError(err).WithField("run_id", run.ID).Warnln("Could not insert log entry for route run")
+		return
+	}
+
+	a.transmitShared(entry.UserID, route.HouseholdID, "CREATE_LOG_ENTRY", entry)
+}
@@ -35,6 +35,27 @@ var upgrader = websocket.Upgrader{
 var robotCtxs = make(map[uuid.UUID]*gin.Context)
 var robotCtxsMutex = &sync.Mutex{}
 
+// robotWriteMutexes has a mutex for each connection in robotCtxs, as a websocket connection can only be written to
+// by one goroutine at a time. Always write to robots with writeRobot, which takes care of it.
+var robotWriteMutexes = make(map[uuid.UUID]*sync.Mutex)
+
+// writeRobot sends the payload to the robot, and returns whether it is connected
+func writeRobot(rid uuid.UUID, payload interface{}) (bool, error) {
+	robotCtxsMutex.Lock()
+	wctx, ok := robotCtxs[rid]
+	mux := robotWriteMutexes[rid]
+	robotCtxsMutex.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	return true, wctx.MustGet("ws").(*websocket.Conn).WriteJSON(payload)
+}
+
 var robotStreams = make(map[uuid.UUID]*Stream)
 var robotStreamsMutex = &sync.Mutex{}
 
@@ -164,14 +185,16 @@ func (a *API) streamRobotPlantCapturePhoto(ctx *gin.Context, data map[string]int
 
 	a.Log.WithField("plant_id", plantID).Infoln("PLANT_CAPTURE_PHOTO inserting into db")
 
-	rows, err := a.DB.NamedQuery("insert into plant_photos(filename, plant_id) values (:filename, :plant_id)", photo)
-	defer rows.Close()
+	err = a.DB.Get(&photo.ID, "insert into plant_photos(filename, plant_id) values ($1, $2) returning id", photo.Filename, photo.PlantID)
 	if err != nil {
 		_ = a.Bucket.Delete(ctx, filename)
 		a.Log.WithError(err).WithField("plant_id", plantID).Warnln("could not insert file for PLANT_CAPTURE_PHOTO")
 		return
 	}
 
+	// Let analysePhotos get to it straight away, rather than at its next interval
+	a.queuePhotoAnalysis()
+
 	a.Log.WithField("plant_id", plantID).Infoln("PLANT_CAPTURE_PHOTO done")
 }
 
@@ -182,20 +205,20 @@ func (a *API) streamRobotCreateLogEntry(data map[string]interface{}, rid uuid.UU
 		plantID = &id
 	}
 
-	var uid *int
-	err := a.DB.Get(&uid, "select user_id from robots where id=$1", rid)
+	robot := models.Robot{}
+	err := a.DB.Get(&robot, "select user_id, household_id from robots where id=$1", rid)
 	if err != nil {
 		a.Log.WithField("data", data).WithError(err).Warnln("could not get user id for CREATE_LOG_ENTRY")
 		return
 	}
 
 	// Forget log entries when the robot is unregistered
-	if uid == nil {
+	if robot.UserID == nil {
 		return
 	}
 
 	entry := LogEntry{
-		UserID:   *uid,
+		UserID:   *robot.UserID,
 		Type:     data["type"].(string),
 		Message:  data["message"].(string),
 		Severity: int(data["severity"].(float64)),
@@ -203,24 +226,23 @@ func (a *API) streamRobotCreateLogEntry(data map[string]interface{}, rid uuid.UU
 		PlantID:  plantID,
 	}
 
-	rows, err := a.DB.NamedQuery("insert into log(user_id, type, message, severity, robot_id, plant_id) values (:user_id, :type, :message, :severity, :robot_id, :plant_id) returning id, created_at", entry)
-	defer rows.Close()
-	if err != nil {
+	if err := a.insertLogEntry(&entry); err != nil {
 		a.Log.WithError(err).WithField("data", data).Warnln("could not insert log entry for CREATE_LOG_ENTRY")
 		return
 	}
 
-	if !rows.Next() {
-		a.Log.Warnln("Expected rows.Next() to return true for CREATE_LOG_ENTRY")
-		return
-	}
+	a.transmitShared(entry.UserID, robot.HouseholdID, "CREATE_LOG_ENTRY", entry)
+}
 
-	if err := rows.StructScan(&entry); err != nil {
-		a.Log.WithError(err).WithField("data", data).Warnln("could not scan log entry for CREATE_LOG_ENTRY")
-		return
+// robotCanServe returns whether the robot can act on the plant.
+// Robots can only update plants of the same owner, or plants in the same household.
+func robotCanServe(robot *models.Robot, plant *models.Plant) bool {
+	if robot.UserID == nil {
+		return false
 	}
 
-	a.userStreams.transmit(entry.UserID, "CREATE_LOG_ENTRY", entry)
+	sameHousehold := plant.HouseholdID != nil && robot.HouseholdID != nil && *plant.HouseholdID == *robot.HouseholdID
+	return plant.UserID == *robot.UserID || sameHousehold
 }
 
 func (a *API) streamRobotUpdateSoilMoisture(data map[string]interface{}, robot *models.Robot) {
@@ -233,13 +255,14 @@ func (a *API) streamRobotUpdateSoilMoisture(data map[string]interface{}, robot *
 	plantID := int(data["plant_id"].(float64))
 
 	plant := models.Plant{}
-	err := a.DB.Get(&plant, "select user_id from plants where id=$1", plantID)
+	err := a.DB.Get(&plant, "select user_id, household_id, archived_at from plants where id=$1", plantID)
 	if err != nil {
 		a.Log.WithField("data", data).WithError(err).Warnln("could not get plant for UPDATE_SOIL_MOISTURE")
 		return
 	}
 
-	if plant.UserID != *robot.UserID {
+	// Archived plants are left as they were, so that nothing acts on them
+	if plant.ArchivedAt != nil || !robotCanServe(robot, &plant) {
 		return
 	}
 
@@ -250,10 +273,13 @@ func (a *API) streamRobotUpdateSoilMoisture(data map[string]interface{}, robot *
 		return
 	}
 
-	a.userStreams.transmit(plant.UserID, "UPDATE_SOIL_MOISTURE", map[string]interface{}{
+	a.transmitShared(plant.UserID, plant.HouseholdID, "UPDATE_SOIL_MOISTURE", map[string]interface{}{
 		"plant_id": plantID,
 		"moisture": moisture,
 	})
+
+	a.evaluateWateringRules(plantID, moisture)
+	a.updatePlantHealth(plantID)
 }
 
 func (a *API) StreamRobot(ctx *gin.Context) {
@@ -280,7 +306,7 @@ func (a *API) StreamRobot(ctx *gin.Context) {
 		}
 
 		if robot.UserID != nil {
-			a.userStreams.transmit(*robot.UserID, "UPDATE_ROBOT_STATE", map[string]interface{}{"id": rid, "seen_at": now})
+			a.transmitShared(*robot.UserID, robot.HouseholdID, "UPDATE_ROBOT_STATE", map[string]interface{}{"id": rid, "seen_at": now})
 		}
 	}
 	updateSeenAt()
@@ -298,6 +324,7 @@ func (a *API) StreamRobot(ctx *gin.Context) {
 
 		// Add the new context
 		robotCtxs[rid] = ctx
+		robotWriteMutexes[rid] = &sync.Mutex{}
 
 		robotCtxsMutex.Unlock()
 	}
@@ -310,19 +337,34 @@ func (a *API) StreamRobot(ctx *gin.Context) {
 
 		if robotCtxs[rid] == ctx {
 			delete(robotCtxs, rid)
+			delete(robotWriteMutexes, rid)
+
+			// Hand over whatever the robot was doing, unless it has already reconnected
+			go a.expireTasks("robot_id = $2", rid)
+			go a.abortRouteRuns(rid, "as the robot went offline")
 		}
 	}()
 
 	// On first load, gather events, and push to client
 	a.pingRobotEvents(rid, true)
 
+	// Along with where its plants are, and its own map
+	a.sendPlantMap(rid)
+	a.sendRobotMap(ctx, rid)
+
+	// And when it has to stay docked
+	a.sendDockingSchedule(rid)
+
+	// Robots that were decommissioned while offline reset now
+	a.sendFactoryReset(rid)
+
 	{
 		var standby bool
 		if err := a.DB.Get(&standby, "select standby from robot_state where id = $1", rid); err != nil {
 			a.Log.WithError(err).WithField("rid", rid).Warnln("Could not read standby from db")
 		} else {
 			payload := payloadSetStandby(standby)
-			c.WriteJSON(payload)
+			writeRobot(rid, payload)
 		}
 	}
 
@@ -333,6 +375,12 @@ func (a *API) StreamRobot(ctx *gin.Context) {
 			break
 		}
 
+		// The robot may have been transferred, unregistered or decommissioned since it connected
+		if err := a.DB.Get(robot, "select * from robots where id = $1", rid); err != nil {
+			a.Log.WithError(err).WithField("rid", rid).Warnln("Could not read robot from db")
+			continue
+		}
+
 		updateSeenAt()
 
 		msg := struct {
@@ -356,6 +404,42 @@ func (a *API) StreamRobot(ctx *gin.Context) {
 		case "UPDATE_SOIL_MOISTURE":
 			a.streamRobotUpdateSoilMoisture(msg.Data, robot)
 
+		case "PLANT_WATERED":
+			a.streamRobotPlantWatered(msg.Data, robot)
+
+		case "UPDATE_ROBOT_STATE":
+			a.streamRobotUpdateState(msg.Data, robot)
+
+		case "MAP_UPLOAD":
+			a.streamRobotMapUpload(ctx, msg.Data, robot)
+
+		case "TASK_CLAIM":
+			a.streamRobotTaskClaim(msg.Data, robot)
+
+		case "TASK_HEARTBEAT":
+			a.streamRobotTaskHeartbeat(msg.Data, robot)
+
+		case "TASK_COMPLETE":
+			a.streamRobotTaskComplete(msg.Data, robot)
+
+		case "ROUTE_STARTED":
+			a.streamRobotRouteStarted(msg.Data, robot)
+
+		case "ROUTE_PROGRESS":
+			a.streamRobotRouteProgress(msg.Data, robot)
+
+		case "ROUTE_FINISHED":
+			a.streamRobotRouteFinished(msg.Data, robot)
+
+		case "PLANT_TAG_SCANNED":
+			a.streamRobotPlantTagScanned(msg.Data, robot)
+
+		case "GET_PLANT_MAP":
+			a.sendPlantMap(rid)
+
+		case "FACTORY_RESET_COMPLETE":
+			a.streamRobotFactoryResetComplete(rid)
+
 		default:
 			a.Log.WithField("Type", msg.Type).Warnln("Received message with unk type from robot stream")
 		}
@@ -0,0 +1,353 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// TaskLeaseDuration is how long a claim lasts without a heartbeat from the robot
+const TaskLeaseDuration = time.Minute * 2
+
+// TaskReapInterval is how often expired leases are looked for
+const TaskReapInterval = time.Second * 15
+
+// sendRobotMessage sends a message to the robot, if it is connected
+func (a *API) sendRobotMessage(rid uuid.UUID, msgType string, data interface{}) {
+	payload := struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{msgType, data}
+
+	if _, err := writeRobot(rid, payload); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not send " + msgType)
+	}
+}
+
+// transmitTask lets the users who can see the plant know that a lease has changed
+func (a *API) transmitTask(lease *models.TaskLease) {
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select user_id, household_id from plants where id = $1", lease.PlantID); err != nil {
+		a.Log.WithError(err).WithField("lease_id", lease.ID).Warnln("Could not get plant for TASK_UPDATE")
+		return
+	}
+
+	a.transmitShared(plant.UserID, plant.HouseholdID, "TASK_UPDATE", lease)
+}
+
+// streamRobotTaskClaim is sent by the robot before it waters or photographs a plant, with the
+// "plant_id", the "action" name, and optionally the "event_id" and "data" of the action. Claims with an "event_id" also
+// need the "scheduled_at" (RFC 3339) of the occurrence, otherwise they are refused with "missing_scheduled_at".
+//
+// The robot is sent TASK_CLAIM_RESULT, and should only carry out the task if it was "granted".
+// Claims for an occurrence of an event that has already been carried out are refused, so that robots
+// running the same schedule don't both carry it out. Claims without an event are never refused this way.
+func (a *API) streamRobotTaskClaim(data map[string]interface{}, robot *models.Robot) {
+	plantID, _ := data["plant_id"].(float64)
+	name, _ := data["action"].(string)
+
+	result := gin.H{
+		"plant_id": int(plantID),
+		"action":   name,
+		"granted":  false,
+	}
+	defer func() {
+		a.sendRobotMessage(robot.ID, "TASK_CLAIM_RESULT", result)
+	}()
+
+	if name != models.EventActionPlantWater && name != models.EventActionPlantCapturePhoto {
+		result["reason"] = "unknown_action"
+		return
+	}
+
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select id, user_id, household_id from plants where id = $1", int(plantID)); err != nil || !robotCanServe(robot, &plant) {
+		result["reason"] = "unknown_plant"
+		return
+	}
+
+	if err := a.canDispatch(robot.ID); err != nil {
+		result["reason"] = dispatchReason(err)
+		if result["reason"] == "" {
+			a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not check docking for TASK_CLAIM")
+			result["reason"] = "error"
+		}
+		return
+	}
+
+	var eventID *int
+	if v, ok := data["event_id"].(float64); ok {
+		id := int(v)
+		eventID = &id
+	}
+
+	var scheduledAt *time.Time
+	if v, ok := data["scheduled_at"].(string); ok {
+		if at, err := time.Parse(time.RFC3339, v); err == nil {
+			at = at.UTC()
+			scheduledAt = &at
+		}
+	}
+
+	// Without it, every occurrence of the event would look like the first one that was done
+	if eventID != nil && scheduledAt == nil {
+		result["reason"] = "missing_scheduled_at"
+		return
+	}
+
+	actionData := types.JSONText("{}")
+	if v, ok := data["data"].(map[string]interface{}); ok {
+		if b, err := json.Marshal(v); err == nil {
+			actionData = types.JSONText(b)
+		}
+	}
+
+	if eventID != nil {
+		var done bool
+		err := a.DB.Get(
+			&done,
+			"select exists(select 1 from task_leases where plant_id = $1 and name = $2 and status = $3 and event_id = $4 and scheduled_at = $5)",
+			plant.ID, name, models.TaskStatusCompleted, *eventID, scheduledAt,
+		)
+		if err != nil {
+			a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not check done tasks for TASK_CLAIM")
+			result["reason"] = "error"
+			return
+		} else if done {
+			result["reason"] = "already_done"
+			return
+		}
+	}
+
+	var lease models.TaskLease
+	err := a.DB.Get(
+		&lease,
+		`insert into task_leases(plant_id, name, robot_id, event_id, scheduled_at, data, expires_at) values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (plant_id, name) where status = 'claimed' do nothing
+		returning *`,
+		plant.ID, name, robot.ID, eventID, scheduledAt, actionData, time.Now().UTC().Add(TaskLeaseDuration),
+	)
+
+	if err == sql.ErrNoRows {
+		// Someone holds it already, which is fine if it's this robot asking again
+		err = a.DB.Get(&lease, "select * from task_leases where plant_id = $1 and name = $2 and status = $3", plant.ID, name, models.TaskStatusClaimed)
+		if err == nil && lease.RobotID != robot.ID {
+			result["reason"] = "claimed"
+			result["robot_id"] = lease.RobotID
+			return
+		}
+	}
+
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not claim task for TASK_CLAIM")
+		result["reason"] = "error"
+		return
+	}
+
+	result["granted"] = true
+	result["lease_id"] = lease.ID
+	result["expires_at"] = lease.ExpiresAt
+
+	a.transmitTask(&lease)
+}
+
+// streamRobotTaskHeartbeat is sent by the robot every so often while it carries out a task, with the "lease_id".
+// If the lease has already expired, the robot is sent TASK_LEASE_LOST and should stop.
+func (a *API) streamRobotTaskHeartbeat(data map[string]interface{}, robot *models.Robot) {
+	leaseID, _ := data["lease_id"].(float64)
+
+	var expiresAt time.Time
+	err := a.DB.Get(
+		&expiresAt,
+		"update task_leases set heartbeat_at = timezone('utc', now()), expires_at = $4 where id = $1 and robot_id = $2 and status = $3 returning expires_at",
+		int(leaseID), robot.ID, models.TaskStatusClaimed, time.Now().UTC().Add(TaskLeaseDuration),
+	)
+	if err == sql.ErrNoRows {
+		a.sendRobotMessage(robot.ID, "TASK_LEASE_LOST", gin.H{"lease_id": int(leaseID)})
+		return
+	} else if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not extend lease for TASK_HEARTBEAT")
+		return
+	}
+
+	a.sendRobotMessage(robot.ID, "TASK_LEASE_EXTENDED", gin.H{"lease_id": int(leaseID), "expires_at": expiresAt})
+}
+
+// streamRobotTaskComplete is sent by the robot once it is done with a task, with the "lease_id" and whether it was a "success"
+func (a *API) streamRobotTaskComplete(data map[string]interface{}, robot *models.Robot) {
+	leaseID, _ := data["lease_id"].(float64)
+	success, _ := data["success"].(bool)
+
+	status := models.TaskStatusCompleted
+	if !success {
+		status = models.TaskStatusFailed
+	}
+
+	var lease models.TaskLease
+	err := a.DB.Get(
+		&lease,
+		"update task_leases set status = $4, finished_at = timezone('utc', now()) where id = $1 and robot_id = $2 and status = $3 returning *",
+		int(leaseID), robot.ID, models.TaskStatusClaimed, status,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).WithField("lease_id", int(leaseID)).Warnln("Could not complete lease for TASK_COMPLETE")
+		return
+	}
+
+	a.transmitTask(&lease)
+}
+
+// expireTasks expires the leases matching the condition (with $2 onwards), and hands their tasks to other robots
+func (a *API) expireTasks(condition string, args ...interface{}) {
+	leases := []models.TaskLease{}
+	err := a.DB.Select(
+		&leases,
+		"update task_leases set status = $1, finished_at = timezone('utc', now()) where status = 'claimed' and "+condition+" returning *",
+		append([]interface{}{models.TaskStatusExpired}, args...)...,
+	)
+	if err != nil {
+		a.Log.WithError(err).Warnln("Could not expire task leases")
+		return
+	}
+
+	for i := range leases {
+		a.transmitTask(&leases[i])
+		a.reassignTask(&leases[i])
+	}
+}
+
+// reassignTask hands the task of an expired lease to another connected robot that can reach the plant
+func (a *API) reassignTask(lease *models.TaskLease) {
+	plant := models.Plant{}
+	if err := a.DB.Get(&plant, "select id, name, user_id, household_id from plants where id = $1", lease.PlantID); err != nil {
+		a.Log.WithError(err).WithField("lease_id", lease.ID).Warnln("Could not get plant to reassign task")
+		return
+	}
+
+	candidates := []uuid.UUID{}
+	err := a.DB.Select(
+		&candidates,
+		"select id from robots where id != $1 and (user_id = $2 or (household_id is not null and household_id = $3)) order by created_at",
+		lease.RobotID, plant.UserID, plant.HouseholdID,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("lease_id", lease.ID).Warnln("Could not get robots to reassign task")
+		return
+	}
+
+	entry := LogEntry{
+		UserID:   plant.UserID,
+		Type:     "task",
+		Message:  fmt.Sprintf("A robot stopped responding while doing %s for %s, and no other robot is online to take over", lease.Name, plant.Name),
+		Severity: LogSeverityWarning,
+		RobotID:  &lease.RobotID,
+		PlantID:  &plant.ID,
+	}
+
+	for _, rid := range candidates {
+		if !isRobotConnected(rid) {
+			continue
+		}
+
+		if lease.Name == models.EventActionPlantWater {
+			if paused, err := a.isWateringPaused(rid); err != nil || paused {
+				continue
+			}
+		}
+
+		if err := a.canDispatch(rid); err != nil {
+			continue
+		}
+
+		action := models.EventAction{
+			Name:    lease.Name,
+			Data:    lease.Data,
+			PlantID: &plant.ID,
+			RobotID: rid,
+		}
+
+		if err := a.runNow(plant.UserID, "Taking over "+lease.Name+" for "+plant.Name, action); err != nil {
+			a.Log.WithError(err).WithField("lease_id", lease.ID).Warnln("Could not reassign task")
+			continue
+		}
+
+		entry.Message = fmt.Sprintf("A robot stopped responding while doing %s for %s, so another robot has taken over", lease.Name, plant.Name)
+		entry.Severity = LogSeverityInfo
+		entry.RobotID = &rid
+		break
+	}
+
+	if err := a.insertLogEntry(&entry); err != nil {
+		a.Log.WithError(err).WithField("lease_id", lease.ID).Warnln("Could not insert log entry for reassigned task")
+		return
+	}
+
+	a.transmitShared(entry.UserID, plant.HouseholdID, "CREATE_LOG_ENTRY", entry)
+}
+
+// reapTasks expires leases that have gone without a heartbeat
+func (a *API) reapTasks() {
+	for range time.Tick(TaskReapInterval) {
+		a.expireTasks("expires_at < $2", time.Now().UTC())
+	}
+}
+
+// TaskListGet lists the leases of plants the user owns or that are shared with their households, newest first.
+// Takes an optional "status" and "plant_id".
+func (a *API) TaskListGet(c *gin.Context) {
+	input := struct {
+		Status  *string `form:"status"`
+		PlantID *int    `form:"plant_id"`
+		Limit   int     `form:"limit,default=50"`
+		Offset  int     `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if s := input.Status; s != nil && *s != models.TaskStatusClaimed && *s != models.TaskStatusCompleted && *s != models.TaskStatusFailed && *s != models.TaskStatusExpired {
+		a.error(c, http.StatusBadRequest, "status must be one of claimed, completed, failed or expired")
+		return
+	}
+
+	leases := []models.TaskLease{}
+	err := a.DB.Select(
+		&leases,
+		`select * from task_leases where plant_id in (select id from plants where user_id = $1 or household_id in `+sqlMyHouseholds+`)
+		and ($2::task_status is null or status = $2) and ($3::integer is null or plant_id = $3)
+		order by claimed_at desc limit $4 offset $5`,
+		c.GetInt("user_id"), input.Status, input.PlantID, input.Limit, input.Offset,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": leases,
+	})
+}
+
+// PlantTaskListGet lists the leases of the plant, newest first
+func (a *API) PlantTaskListGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	leases := []models.TaskLease{}
+	if err := a.DB.Select(&leases, "select * from task_leases where plant_id = $1 order by claimed_at desc limit 50", plant.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": leases,
+	})
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// accessTokenPrefix is prepended to every personal access token so that they can be told apart from JWTs
+const accessTokenPrefix = "gbt_"
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash stored in the database for a plaintext token.
+//
+// Tokens are long and random, so a plain sha256 is enough here (unlike passwords, which use bcrypt).
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// accessTokenFromRequest returns the personal access token supplied with the request, if any
+func accessTokenFromRequest(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer "+accessTokenPrefix) {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+
+	if q := c.Query("token"); strings.HasPrefix(q, accessTokenPrefix) {
+		return q
+	}
+
+	return ""
+}
+
+// TokenAuth wraps the jwt middleware so that personal access tokens are accepted too.
+//
+// Requests carrying an access token get "user_id" and "access_token" set on the context,
+// everything else is handed over to the jwt middleware.
+func (a *API) TokenAuth(jwtAuth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := accessTokenFromRequest(c)
+		if raw == "" {
+			jwtAuth(c)
+			return
+		}
+
+		var token models.AccessToken
+		err := a.DB.Get(&token, "select t.* from access_tokens as t, users as u where t.token_hash = $1 and (t.expires_at is null or t.expires_at > timezone('utc', now())) and u.id = t.user_id and u.is_activated", hashToken(raw))
+		if err == sql.ErrNoRows {
+			a.jwtUnauthorized(c, http.StatusUnauthorized, "invalid or expired access token")
+			c.Abort()
+			return
+		} else if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			c.Abort()
+			return
+		}
+
+		_, err = a.DB.Exec("update access_tokens set last_used_at = timezone('utc', now()) where id = $1", token.ID)
+		if err != nil {
+			a.Log.WithError(err).WithField("token_id", token.ID).Warnln("could not update last_used_at")
+		}
+
+		c.Set("user_id", token.UserID)
+		c.Set("access_token", &token)
+		c.Next()
+	}
+}
+
+// SessionCheck is a middleware that rejects requests authenticated with a personal access token.
+// It guards account management routes, which should always require a proper login.
+func (a *API) SessionCheck(c *gin.Context) {
+	if _, ok := c.Get("access_token"); ok {
+		a.error(c, http.StatusForbidden, "This cannot be done with an access token")
+		c.Abort()
+	}
+}
+
+// ScopeCheck returns a middleware that makes sure the access token used (if any)
+// has been granted the read scope for GET requests, or the write scope otherwise.
+func (a *API) ScopeCheck(read, write string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.scopeCheck(c, read, write) {
+			c.Abort()
+		}
+	}
+}
+
+// scopeCheck is the function form of ScopeCheck, for use inside other middlewares.
+// Requests made with a JWT are always allowed through.
+func (a *API) scopeCheck(c *gin.Context, read, write string) bool {
+	v, ok := c.Get("access_token")
+	if !ok {
+		return true
+	}
+
+	scope := write
+	if c.Request.Method == http.MethodGet {
+		scope = read
+	}
+
+	if v.(*models.AccessToken).HasScope(scope) {
+		return true
+	}
+
+	a.error(c, http.StatusForbidden, "This access token has not been granted the "+scope+" scope")
+	return false
+}
+
+// TokenListGet lists the access tokens of the current user
+func (a *API) TokenListGet(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	tokens := []models.AccessToken{}
+	err := a.DB.Select(&tokens, "select * from access_tokens where user_id = $1 order by created_at desc", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+		"scopes": models.Scopes,
+	})
+}
+
+// TokenCreatePost creates an access token.
+//
+// Takes a name, a list of scopes, and an optional expires_at.
+// The plaintext token is only ever returned here.
+func (a *API) TokenCreatePost(c *gin.Context) {
+	input := struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Tokens must have a name")
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		a.error(c, http.StatusBadRequest, "Tokens must have at least one scope")
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !models.ValidScope(scope) {
+			a.error(c, http.StatusBadRequest, "Unknown scope "+scope)
+			return
+		}
+	}
+
+	if input.ExpiresAt != nil {
+		utc := input.ExpiresAt.UTC()
+		if utc.Before(time.Now()) {
+			a.error(c, http.StatusBadRequest, "Tokens cannot expire in the past")
+			return
+		}
+		input.ExpiresAt = &utc
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	raw := accessTokenPrefix + secret
+
+	row := models.AccessToken{
+		UserID:    c.GetInt("user_id"),
+		Name:      input.Name,
+		TokenHash: hashToken(raw),
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	rows, err := a.DB.NamedQuery("insert into access_tokens(user_id, name, token_hash, scopes, expires_at) values (:user_id, :name, :token_hash, :scopes, :expires_at) returning id", row)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		a.error(c, http.StatusInternalServerError, "Expected rows.Next() to return true")
+		return
+	}
+
+	var id int
+	if err := rows.Scan(&id); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "token.create", "access_token", strconv.Itoa(id), &row.UserID, gin.H{"name": input.Name, "scopes": input.Scopes, "expires_at": input.ExpiresAt})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":    id,
+		"token": raw,
+	})
+}
+
+// TokenDelete revokes an access token belonging to the current user
+func (a *API) TokenDelete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		return
+	}
+
+	result, err := a.DB.Exec("delete from access_tokens where id = $1 and user_id = $2", id, c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if n == 0 {
+		a.error(c, http.StatusNotFound, "Token does not exist")
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	a.audit(c, "token.revoke", "access_token", strconv.Itoa(id), &userID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// RobotTransferTimeout is how long the recipient of a robot has to accept it
+const RobotTransferTimeout = time.Hour * 24 * 7
+
+// RobotTransferPost starts handing the robot over to someone else.
+//
+// Takes the "email" of the recipient, and a "mode": "migrate" to give them the robot's
+// events and settings, or "clear" to give them the robot as if it was new.
+// Starting a new transfer replaces any pending one.
+func (a *API) RobotTransferPost(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+	userID := c.GetInt("user_id")
+
+	if !a.ownerCheck(c, robot.UserID, "robot") {
+		return
+	}
+
+	input := struct {
+		Email string `json:"email"`
+		Mode  string `json:"mode"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Mode != models.TransferModeMigrate && input.Mode != models.TransferModeClear {
+		a.error(c, http.StatusBadRequest, "mode must be either migrate or clear")
+		return
+	}
+
+	if ok, msg := validateEmail(input.Email); !ok {
+		a.error(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	var email string
+	if err := a.DB.Get(&email, "select email from users where id = $1", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if email == input.Email {
+		a.error(c, http.StatusBadRequest, "You already own this robot")
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from robot_transfers where robot_id = $1", robot.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var id int
+	err = tx.Get(
+		&id,
+		"insert into robot_transfers(robot_id, from_user_id, email, mode, token_hash, expires_at) values ($1, $2, $3, $4, $5, $6) returning id",
+		robot.ID, userID, input.Email, input.Mode, hashToken(token), time.Now().UTC().Add(RobotTransferTimeout),
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	title := "a robot"
+	if robot.Title != nil && *robot.Title != "" {
+		title = "the robot \"" + *robot.Title + "\""
+	}
+
+	link := a.Config.FrontendURL + "/transfer?token=" + url.QueryEscape(token)
+	body := "Someone wants to give you " + title + " on GrowBot.\n\n" +
+		"To accept it, log in (or create an account with this email address) and follow this link within 7 days:\n\n" + link
+
+	if err := a.Mailer.Send(input.Email, "You've been given a GrowBot robot", body); err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not send robot transfer email")
+		a.error(c, http.StatusInternalServerError, "Could not send transfer email")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.audit(c, "robot.transfer.start", "robot", robot.ID.String(), &userID, input)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// RobotTransferDelete cancels the pending transfer of the robot
+func (a *API) RobotTransferDelete(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	if !a.ownerCheck(c, robot.UserID, "robot") {
+		return
+	}
+
+	result, err := a.DB.Exec("delete from robot_transfers where robot_id = $1", robot.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if n == 0 {
+		a.error(c, http.StatusNotFound, "This robot is not being transferred")
+		return
+	}
+
+	a.audit(c, "robot.transfer.cancel", "robot", robot.ID.String(), robot.UserID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// TransferListGet lists the pending transfers to and from the current user
+func (a *API) TransferListGet(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	incoming := []struct {
+		models.RobotTransfer
+		Title *string `json:"title" db:"title"`
+	}{}
+	err := a.DB.Select(&incoming, "select t.*, r.title from robot_transfers as t, robots as r, users as u where u.id = $1 and t.email = u.email and r.id = t.robot_id and t.expires_at > timezone('utc', now()) order by t.created_at desc", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outgoing := []models.RobotTransfer{}
+	err = a.DB.Select(&outgoing, "select * from robot_transfers where from_user_id = $1 order by created_at desc", userID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"incoming": incoming,
+		"outgoing": outgoing,
+	})
+}
+
+// TransferAcceptPost takes the "token" of a transfer, and gives the robot to the current user
+func (a *API) TransferAcceptPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var transfer models.RobotTransfer
+	err = tx.Get(&transfer, "delete from robot_transfers where token_hash = $1 and expires_at > timezone('utc', now()) returning *", hashToken(input.Token))
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusBadRequest, "This transfer is invalid or has expired")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var email string
+	if err := tx.Get(&email, "select email from users where id = $1", userID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if email != transfer.Email {
+		a.error(c, http.StatusForbidden, "This transfer was sent to a different email address")
+		return
+	}
+
+	// Make sure the robot still belongs to whoever started the transfer
+	var robot models.Robot
+	err = tx.Get(&robot, "select * from robots where id = $1 and user_id = $2 for update", transfer.RobotID, transfer.FromUserID)
+	if err == sql.ErrNoRows {
+		a.error(c, http.StatusConflict, "This robot no longer belongs to the person who sent it")
+		return
+	} else if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	migrate := transfer.Mode == models.TransferModeMigrate
+
+	// Maps are removed along with everything else when clearing the robot
+	maps := []uuid.UUID{}
+	if !migrate {
+		if err := tx.Select(&maps, "select filename from robot_maps where robot_id = $1", robot.ID); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Events only acting on this robot go with it (or are removed). Events that also act
+	// on other robots stay with the previous owner, minus this robot's actions.
+	exclusive := "id in (select event_id from event_actions where robot_id = $1) and not exists (select 1 from event_actions as a where a.event_id = events.id and a.robot_id != $1)"
+
+	type step struct {
+		query string
+		args  []interface{}
+	}
+
+	steps := []step{
+		{"delete from events where user_id = $2 and " + exclusive, []interface{}{robot.ID, transfer.FromUserID}},
+		{"delete from event_actions where robot_id = $1 and event_id in (select id from events where user_id = $2)", []interface{}{robot.ID, transfer.FromUserID}},
+		{"update robots set user_id = $2, household_id = null, title = 'Unnamed Robot' where id = $1", []interface{}{robot.ID, userID}},
+		{"update robot_state set standby = true where id = $1", []interface{}{robot.ID}},
+		{"delete from robot_alerts where robot_id = $1", []interface{}{robot.ID}},
+		{"delete from robot_docking where robot_id = $1", []interface{}{robot.ID}},
+		{"delete from robot_maps where robot_id = $1", []interface{}{robot.ID}},
+		{"delete from watering_rules where robot_id = $1", []interface{}{robot.ID}},
+	}
+
+	if migrate {
+		steps = []step{
+			{"update events set user_id = $3, household_id = null where user_id = $2 and " + exclusive, []interface{}{robot.ID, transfer.FromUserID, userID}},
+			{"delete from event_actions where robot_id = $1 and event_id in (select id from events where user_id = $2)", []interface{}{robot.ID, transfer.FromUserID}},
+			// The plants stay with the previous owner
+			{"update event_actions set plant_id = null where robot_id = $1", []interface{}{robot.ID}},
+			{"update robots set user_id = $2, household_id = null where id = $1", []interface{}{robot.ID, userID}},
+			// Watering rules are for the previous owner's plants
+			{"delete from watering_rules where robot_id = $1", []interface{}{robot.ID}},
+			// So are the routes, so the moved events can't run them any more
+			{"delete from event_actions where robot_id = $1 and name = $3 and not exists (select 1 from routes where routes.id = (event_actions.data->>'route_id')::integer and routes.user_id = $2)", []interface{}{robot.ID, userID, models.EventActionRunRoute}},
+		}
+	}
+
+	// Household members' events acting on the robot lose access to it along with everyone else
+	steps = append(steps, step{"delete from event_actions where robot_id = $1 and event_id not in (select id from events where user_id = $2)", []interface{}{robot.ID, userID}})
+
+	for _, s := range steps {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.deleteMapFiles(maps)
+
+	title := "Unnamed Robot"
+	if migrate && robot.Title != nil {
+		title = *robot.Title
+	}
+
+	entries := []LogEntry{
+		{
+			UserID:   transfer.FromUserID,
+			Type:     "robot_transfer",
+			Message:  "Robot \"" + title + "\" has been transferred to " + email,
+			Severity: LogSeverityInfo,
+			RobotID:  &robot.ID,
+		},
+		{
+			UserID:   userID,
+			Type:     "robot_transfer",
+			Message:  "Robot \"" + title + "\" has been transferred to you",
+			Severity: LogSeveritySuccess,
+			RobotID:  &robot.ID,
+		},
+	}
+
+	for _, entry := range entries {
+		if err := a.insertLogEntry(&entry); err != nil {
+			a.Log.WithError(err).WithField("rid", robot.ID).Warnln("Could not insert log entry for robot transfer")
+			continue
+		}
+		a.userStreams.transmit(entry.UserID, "CREATE_LOG_ENTRY", entry)
+	}
+
+	a.audit(c, "robot.transfer.accept", "robot", robot.ID.String(), &transfer.FromUserID, gin.H{"mode": transfer.Mode})
+
+	// Whatever the robot was doing was for the previous owner
+	a.expireTasks("robot_id = $2", robot.ID)
+	a.abortRouteRuns(robot.ID, "as the robot was transferred")
+
+	// Let the robot know about its new events (and standby, if it was cleared)
+	a.pingRobotEvents(robot.ID, true)
+	if !migrate {
+		writeRobot(robot.ID, payloadSetStandby(true))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"robot_id": robot.ID,
+	})
+}
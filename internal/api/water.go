@@ -0,0 +1,411 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// WaterLevelTolerance is how many percent lower than expected a robot's water level can be
+// before we warn about it. Sensors aren't exact, so small differences are expected.
+const WaterLevelTolerance = 10
+
+// WaterUsageDays is how many days of waterings are used to estimate how quickly a tank empties
+const WaterUsageDays = 7
+
+// TankStatus reconciles the water level last reported by a robot with what it has dispensed since
+type TankStatus struct {
+	// Capacity is in millilitres, and 0 if the robot hasn't reported it
+	Capacity      int        `json:"capacity"`
+	ReportedLevel int        `json:"reported_level"`
+	ReportedAt    *time.Time `json:"reported_at"`
+
+	// DispensedSinceReport is how many millilitres were dispensed after the level was reported
+	DispensedSinceReport int `json:"dispensed_since_report"`
+
+	// EstimatedLevel is the reported level, minus what was dispensed since. Unknown without a capacity.
+	EstimatedLevel *int `json:"estimated_level"`
+
+	// DailyUsage is the average millilitres dispensed per day, over the last WaterUsageDays days
+	DailyUsage int `json:"daily_usage"`
+
+	// EmptyAt is when the tank is expected to run out, if it is being used
+	EmptyAt *time.Time `json:"empty_at"`
+}
+
+// dispensedSince returns how many millilitres the robot has dispensed since the given time
+func (a *API) dispensedSince(rid uuid.UUID, since time.Time) (int, error) {
+	var volume int
+	err := a.DB.Get(&volume, "select coalesce(sum(volume), 0) from plant_waterings where robot_id = $1 and created_at > $2", rid, since)
+	return volume, err
+}
+
+// tankStatus works out how full the robot's tank should be, and when it will need refilling
+func (a *API) tankStatus(rid uuid.UUID) (*TankStatus, error) {
+	var state models.RobotState
+	if err := a.DB.Get(&state, "select * from robot_state where id = $1", rid); err != nil {
+		return nil, err
+	}
+
+	status := &TankStatus{
+		Capacity:      state.TankCapacity,
+		ReportedLevel: state.WaterLevel,
+		ReportedAt:    state.WaterLevelAt,
+	}
+
+	now := time.Now().UTC()
+
+	used, err := a.dispensedSince(rid, now.AddDate(0, 0, -WaterUsageDays))
+	if err != nil {
+		return nil, err
+	}
+	status.DailyUsage = used / WaterUsageDays
+
+	if state.WaterLevelAt == nil {
+		return status, nil
+	}
+
+	status.DispensedSinceReport, err = a.dispensedSince(rid, *state.WaterLevelAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.TankCapacity <= 0 {
+		return status, nil
+	}
+
+	remaining := state.WaterLevel*state.TankCapacity/100 - status.DispensedSinceReport
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	level := remaining * 100 / state.TankCapacity
+	status.EstimatedLevel = &level
+
+	if status.DailyUsage > 0 {
+		emptyAt := now.Add(time.Duration(remaining) * time.Hour * 24 / time.Duration(status.DailyUsage))
+		status.EmptyAt = &emptyAt
+	}
+
+	return status, nil
+}
+
+// waterUsage sums up the waterings matching the condition (with $2) per day and per week, over the last few days.
+// Only waterings of plants the user can see are included.
+func (a *API) waterUsage(userID int, condition string, arg interface{}, days int) (daily []models.WaterUsage, weekly []models.WaterUsage, err error) {
+	query := `select
+		date_trunc($4, created_at) as period,
+		sum(volume) as volume,
+		count(*) filter (where success) as waterings,
+		count(*) filter (where not success) as failures
+	from plant_waterings where ` + condition + ` and created_at >= date_trunc('day', timezone('utc', now())) - make_interval(days => $3)
+	and plant_id in (select id from plants where user_id = $1 or household_id in ` + sqlMyHouseholds + `)
+	group by period order by period`
+
+	daily = []models.WaterUsage{}
+	if err := a.DB.Select(&daily, query, userID, arg, days, "day"); err != nil {
+		return nil, nil, err
+	}
+
+	weekly = []models.WaterUsage{}
+	if err := a.DB.Select(&weekly, query, userID, arg, days, "week"); err != nil {
+		return nil, nil, err
+	}
+
+	return daily, weekly, nil
+}
+
+// bindUsageDays reads "days" from the query string, responding and returning false if it's invalid
+func (a *API) bindUsageDays(c *gin.Context) (int, bool) {
+	input := struct {
+		Days int `form:"days,default=30"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return 0, false
+	} else if input.Days < 1 || input.Days > 366 {
+		a.error(c, http.StatusBadRequest, "days must be between 1 and 366")
+		return 0, false
+	}
+
+	return input.Days, true
+}
+
+// streamRobotPlantWatered is sent by the robot after it has watered a plant
+func (a *API) streamRobotPlantWatered(data map[string]interface{}, robot *models.Robot) {
+	plantID, ok := data["plant_id"].(float64)
+	if !ok {
+		a.Log.WithField("data", data).Warnln("no plant_id provided for PLANT_WATERED")
+		return
+	}
+
+	plant := models.Plant{}
+	err := a.DB.Get(&plant, "select id, name, user_id, household_id, archived_at from plants where id=$1", int(plantID))
+	if err != nil {
+		a.Log.WithField("data", data).WithError(err).Warnln("could not get plant for PLANT_WATERED")
+		return
+	}
+
+	if plant.ArchivedAt != nil || !robotCanServe(robot, &plant) {
+		return
+	}
+
+	volume, _ := data["volume"].(float64)
+	duration, _ := data["duration"].(float64)
+	success, _ := data["success"].(bool)
+
+	if volume < 0 || duration < 0 {
+		a.Log.WithField("data", data).Warnln("negative volume or duration for PLANT_WATERED")
+		return
+	}
+
+	watering := models.PlantWatering{
+		PlantID:  plant.ID,
+		RobotID:  &robot.ID,
+		Volume:   int(volume),
+		Duration: int(duration),
+		Success:  success,
+	}
+
+	err = a.DB.Get(
+		&watering,
+		"insert into plant_waterings(plant_id, robot_id, volume, duration, success) values ($1, $2, $3, $4, $5) returning *",
+		watering.PlantID, watering.RobotID, watering.Volume, watering.Duration, watering.Success,
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("data", data).Warnln("could not insert watering for PLANT_WATERED")
+		return
+	}
+
+	a.transmitShared(plant.UserID, plant.HouseholdID, "PLANT_WATERED", watering)
+	a.updatePlantHealth(plant.ID)
+
+	if !success {
+		entry := LogEntry{
+			UserID:   plant.UserID,
+			Type:     "plant_watered",
+			Message:  fmt.Sprintf("Could not water %s (%dml dispensed)", plant.Name, watering.Volume),
+			Severity: LogSeverityWarning,
+			RobotID:  &robot.ID,
+			PlantID:  &plant.ID,
+		}
+
+		if err := a.insertLogEntry(&entry); err != nil {
+			a.Log.WithError(err).WithField("data", data).Warnln("could not insert log entry for PLANT_WATERED")
+			return
+		}
+
+		a.transmitShared(entry.UserID, plant.HouseholdID, "CREATE_LOG_ENTRY", entry)
+	}
+}
+
+// streamRobotUpdateState is sent by the robot with any of its "battery_level", "water_level" and "tank_capacity",
+// and whether it is "docked" and "charging".
+//
+// Water levels are checked against what the robot has dispensed since its last report,
+// and a warning is logged if the tank emptied more quickly than it should have.
+func (a *API) streamRobotUpdateState(data map[string]interface{}, robot *models.Robot) {
+	fields := map[string]*int{
+		"battery_level": nil,
+		"water_level":   nil,
+		"tank_capacity": nil,
+	}
+
+	flags := map[string]*bool{
+		"docked":   nil,
+		"charging": nil,
+	}
+
+	update := map[string]interface{}{"id": robot.ID}
+	for key := range fields {
+		if val, ok := data[key].(float64); ok {
+			v := int(val)
+			fields[key] = &v
+			update[key] = v
+		}
+	}
+	for key := range flags {
+		if val, ok := data[key].(bool); ok {
+			flags[key] = &val
+			update[key] = val
+		}
+	}
+
+	var previous models.RobotState
+	if err := a.DB.Get(&previous, "select * from robot_state where id = $1", robot.ID); err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("could not get robot state for UPDATE_ROBOT_STATE")
+		return
+	}
+
+	_, err := a.DB.Exec(
+		`update robot_state set
+			battery_level = coalesce($2, battery_level),
+			battery_level_at = case when $2::integer is null then battery_level_at else timezone('utc', now()) end,
+			water_level = coalesce($3, water_level),
+			water_level_at = case when $3::integer is null then water_level_at else timezone('utc', now()) end,
+			tank_capacity = coalesce($4, tank_capacity),
+			docked_at = case when $5 and not docked then timezone('utc', now()) else docked_at end,
+			docked = coalesce($5, docked),
+			charging = coalesce($6, charging)
+		where id = $1`,
+		robot.ID, fields["battery_level"], fields["water_level"], fields["tank_capacity"], flags["docked"], flags["charging"],
+	)
+	if err != nil {
+		a.Log.WithError(err).WithField("data", data).Warnln("could not update robot state for UPDATE_ROBOT_STATE")
+		return
+	}
+
+	// Forget the state of unregistered robots
+	if robot.UserID == nil {
+		return
+	}
+
+	a.transmitShared(*robot.UserID, robot.HouseholdID, "UPDATE_ROBOT_STATE", update)
+	a.checkRobotAlerts(robot, fields["battery_level"], fields["water_level"])
+
+	level := fields["water_level"]
+	if level == nil || previous.WaterLevelAt == nil || previous.TankCapacity <= 0 {
+		return
+	}
+
+	dispensed, err := a.dispensedSince(robot.ID, *previous.WaterLevelAt)
+	if err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("could not get dispensed water for UPDATE_ROBOT_STATE")
+		return
+	}
+
+	// A rising level means the tank has been refilled
+	expected := previous.WaterLevel - dispensed*100/previous.TankCapacity
+	if *level >= previous.WaterLevel || *level >= expected-WaterLevelTolerance {
+		return
+	}
+
+	entry := LogEntry{
+		UserID:   *robot.UserID,
+		Type:     "water_level",
+		Message:  fmt.Sprintf("The water tank is at %d%%, but should be at %d%% from what was dispensed. Check the robot for leaks.", *level, expected),
+		Severity: LogSeverityWarning,
+		RobotID:  &robot.ID,
+	}
+
+	if err := a.insertLogEntry(&entry); err != nil {
+		a.Log.WithError(err).WithField("rid", robot.ID).Warnln("could not insert log entry for UPDATE_ROBOT_STATE")
+		return
+	}
+
+	a.transmitShared(entry.UserID, robot.HouseholdID, "CREATE_LOG_ENTRY", entry)
+}
+
+// PlantWateringListGet lists the times the plant was watered, most recent first
+func (a *API) PlantWateringListGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		Limit  int `form:"limit,default=50"`
+		Offset int `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	waterings := []models.PlantWatering{}
+	err := a.DB.Select(&waterings, "select * from plant_waterings where plant_id = $1 order by created_at desc limit $2 offset $3", plant.ID, input.Limit, input.Offset)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"waterings": waterings,
+	})
+}
+
+// PlantWaterUsageGet returns how much water the plant has had per day and per week.
+// Takes an optional "days" to look back over, defaulting to 30.
+func (a *API) PlantWaterUsageGet(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	days, ok := a.bindUsageDays(c)
+	if !ok {
+		return
+	}
+
+	daily, weekly, err := a.waterUsage(c.GetInt("user_id"), "plant_id = $2", plant.ID, days)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"daily":  daily,
+		"weekly": weekly,
+	})
+}
+
+// RobotWateringListGet lists the waterings done by the robot, most recent first.
+// Only waterings of plants the current user can see are included.
+func (a *API) RobotWateringListGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	input := struct {
+		Limit  int `form:"limit,default=50"`
+		Offset int `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	waterings := []models.PlantWatering{}
+	err := a.DB.Select(
+		&waterings,
+		"select * from plant_waterings where robot_id = $2 and plant_id in (select id from plants where user_id = $1 or household_id in "+sqlMyHouseholds+") order by created_at desc limit $3 offset $4",
+		c.GetInt("user_id"), robot.ID, input.Limit, input.Offset,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"waterings": waterings,
+	})
+}
+
+// RobotWaterUsageGet returns how much water the robot has dispensed per day and per week,
+// along with the state of its tank and when it is expected to need refilling.
+// Takes an optional "days" to look back over, defaulting to 30.
+func (a *API) RobotWaterUsageGet(c *gin.Context) {
+	robot := c.MustGet("robot").(*models.Robot)
+
+	days, ok := a.bindUsageDays(c)
+	if !ok {
+		return
+	}
+
+	daily, weekly, err := a.waterUsage(c.GetInt("user_id"), "robot_id = $2", robot.ID, days)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tank, err := a.tankStatus(robot.ID)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"daily":  daily,
+		"weekly": weekly,
+		"tank":   tank,
+	})
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// sqlCanChange is a condition for rows (with user_id and household_id columns) that user $2 can change:
+// their own, or those shared with a household where they aren't a viewer
+const sqlCanChange = "(user_id = $2 or household_id in (select household_id from household_members where user_id = $2 and role != 'viewer'))"
+
+// canUsePlant returns whether the user can set up things acting on the plant
+func (a *API) canUsePlant(userID int, plantID int) (bool, error) {
+	var ok bool
+	err := a.DB.Get(&ok, "select exists(select 1 from plants where id = $1 and archived_at is null and "+sqlCanChange+")", plantID, userID)
+	return ok, err
+}
+
+// canUseRobot returns whether the user can set up things acting with the robot
+func (a *API) canUseRobot(userID int, robotID uuid.UUID) (bool, error) {
+	var ok bool
+	err := a.DB.Get(&ok, "select exists(select 1 from robots where id = $1 and "+sqlCanChange+")", robotID, userID)
+	return ok, err
+}
+
+// canUseWateringRule returns whether the owner of the rule can still use both its robot and its plant
+func (a *API) canUseWateringRule(rule *models.WateringRule) (bool, error) {
+	if ok, err := a.canUseRobot(rule.UserID, rule.RobotID); err != nil || !ok {
+		return false, err
+	}
+	return a.canUsePlant(rule.UserID, rule.PlantID)
+}
+
+// lastWatered returns when the plant was last successfully watered, if ever
+func (a *API) lastWatered(plantID int) (*time.Time, error) {
+	var at *time.Time
+	err := a.DB.Get(&at, "select max(created_at) from plant_waterings where plant_id = $1 and success", plantID)
+	return at, err
+}
+
+// evaluateWateringRules is called whenever a soil moisture reading is stored, and fires the rules of the plant that match
+func (a *API) evaluateWateringRules(plantID int, moisture int) {
+	ids := []int{}
+	err := a.DB.Select(&ids, "select id from watering_rules where plant_id = $1 and enabled and $2 < moisture_below and plant_id in (select id from plants where archived_at is null)", plantID, moisture)
+	if err != nil {
+		a.Log.WithError(err).WithField("plant_id", plantID).Warnln("Could not get watering rules")
+		return
+	}
+
+	for _, id := range ids {
+		if err := a.evaluateWateringRule(id, moisture); err != nil {
+			a.Log.WithError(err).WithField("rule_id", id).Warnln("Could not evaluate watering rule")
+		}
+	}
+}
+
+// evaluateWateringRule fires the rule, unless it is cooling down, has hit its daily cap,
+// or the plant was watered too recently
+func (a *API) evaluateWateringRule(ruleID int, moisture int) error {
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the rule, so that readings arriving together can't both fire it
+	var rule models.WateringRule
+	if err := tx.Get(&rule, "select * from watering_rules where id = $1 for update", ruleID); err != nil {
+		return err
+	}
+
+	// The robot or plant may have been unshared or given away since the rule was set up
+	if ok, err := a.canUseWateringRule(&rule); err != nil {
+		return err
+	} else if !ok {
+		if _, err := tx.Exec("update watering_rules set enabled = false, blocked_reason = 'no_access' where id = $1", rule.ID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		a.logWateringRule(LogEntry{
+			UserID:   rule.UserID,
+			Type:     "watering_rule",
+			Message:  fmt.Sprintf("Rule \"%s\" has been disabled, as you can no longer use its robot or plant", rule.Name),
+			Severity: LogSeverityWarning,
+		}, nil)
+		return nil
+	}
+
+	var recent struct {
+		Cooling bool `db:"cooling"`
+		Today   int  `db:"today"`
+	}
+	err = tx.Get(
+		&recent,
+		`select
+			coalesce(bool_or(created_at > timezone('utc', now()) - make_interval(mins => $2)), false) as cooling,
+			count(*) filter (where created_at >= date_trunc('day', timezone('utc', now()))) as today
+		from watering_rule_firings where rule_id = $1`,
+		rule.ID, rule.Cooldown,
+	)
+	if err != nil {
+		return err
+	}
+
+	if recent.Cooling || recent.Today >= rule.DailyCap {
+		return nil
+	}
+
+	if rule.MinHoursSinceWatering > 0 {
+		last, err := a.lastWatered(rule.PlantID)
+		if err != nil {
+			return err
+		} else if last != nil && time.Since(*last) < time.Duration(rule.MinHoursSinceWatering)*time.Hour {
+			return nil
+		}
+	}
+
+	// Not recorded as a firing, so that the rule fires again once the tank has been refilled
+	if paused, err := a.isWateringPaused(rule.RobotID); err != nil {
+		return err
+	} else if paused && !rule.DryRun {
+		return nil
+	}
+
+	var plant models.Plant
+	if err := tx.Get(&plant, "select * from plants where id = $1", rule.PlantID); err != nil {
+		return err
+	}
+
+	dispatchErr := a.canDispatch(rule.RobotID)
+	if dispatchErr != nil && dispatchReason(dispatchErr) == "" {
+		return dispatchErr
+	}
+
+	entry := LogEntry{
+		UserID:   rule.UserID,
+		Type:     "watering_rule",
+		Severity: LogSeverityInfo,
+		RobotID:  &rule.RobotID,
+		PlantID:  &rule.PlantID,
+	}
+
+	switch {
+	case rule.DryRun:
+		entry.Message = fmt.Sprintf("Rule \"%s\" would have watered %s with %dml (moisture %d%%, dry run)", rule.Name, plant.Name, rule.WaterVolume, moisture)
+
+	case !isRobotConnected(rule.RobotID):
+		// Not recorded as a firing, so that it is tried again with the next reading
+		entry.Message = fmt.Sprintf("Rule \"%s\" could not water %s, the robot is offline", rule.Name, plant.Name)
+		entry.Severity = LogSeverityWarning
+		return a.blockWateringRule(tx, &rule, "offline", entry, plant.HouseholdID)
+
+	case dispatchErr != nil:
+		// Not recorded as a firing either, so that it fires once the robot can go out again
+		entry.Message = fmt.Sprintf("Rule \"%s\" could not water %s, %s", rule.Name, plant.Name, dispatchErr)
+		entry.Severity = LogSeverityWarning
+		return a.blockWateringRule(tx, &rule, dispatchReason(dispatchErr), entry, plant.HouseholdID)
+
+	default:
+		entry.Message = fmt.Sprintf("Rule \"%s\" is watering %s with %dml (moisture %d%%)", rule.Name, plant.Name, rule.WaterVolume, moisture)
+		entry.Severity = LogSeveritySuccess
+	}
+
+	_, err = tx.Exec(
+		"insert into watering_rule_firings(rule_id, plant_id, robot_id, moisture, water_volume, dry_run) values ($1, $2, $3, $4, $5, $6)",
+		rule.ID, rule.PlantID, rule.RobotID, moisture, rule.WaterVolume, rule.DryRun,
+	)
+	if err != nil {
+		return err
+	}
+
+	if rule.BlockedReason != nil {
+		if _, err := tx.Exec("update watering_rules set blocked_reason = null where id = $1", rule.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The robot is only sent out once the firing is recorded, so that the plant can't be watered without one
+	if !rule.DryRun {
+		data, _ := json.Marshal(map[string]interface{}{"volume": rule.WaterVolume})
+		action := models.EventAction{
+			Name:    models.EventActionPlantWater,
+			Data:    types.JSONText(data),
+			PlantID: &rule.PlantID,
+			RobotID: rule.RobotID,
+		}
+
+		if err := a.runNow(rule.UserID, "Watering rule: "+rule.Name, action); err != nil {
+			a.Log.WithError(err).WithField("rule_id", rule.ID).Warnln("Could not send robot to water for watering rule")
+			entry.Message = fmt.Sprintf("Rule \"%s\" could not water %s, %s", rule.Name, plant.Name, err)
+			entry.Severity = LogSeverityWarning
+		}
+	}
+
+	a.logWateringRule(entry, plant.HouseholdID)
+	return nil
+}
+
+// blockWateringRule records why the rule couldn't water the plant, and commits the transaction.
+// The entry is only logged when the rule first gets blocked for that reason, rather than for every reading until it isn't.
+func (a *API) blockWateringRule(tx *sqlx.Tx, rule *models.WateringRule, reason string, entry LogEntry, householdID *int) error {
+	if rule.BlockedReason != nil && *rule.BlockedReason == reason {
+		return nil
+	}
+
+	if _, err := tx.Exec("update watering_rules set blocked_reason = $2 where id = $1", rule.ID, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.logWateringRule(entry, householdID)
+	return nil
+}
+
+func (a *API) logWateringRule(entry LogEntry, householdID *int) {
+	if err := a.insertLogEntry(&entry); err != nil {
+		a.Log.WithError(err).WithField("plant_id", entry.PlantID).Warnln("Could not insert log entry for watering rule")
+		return
+	}
+
+	a.transmitShared(entry.UserID, householdID, "CREATE_LOG_ENTRY", entry)
+}
+
+// WateringRuleCheck is a middleware to check whether the passed watering rule exists,
+// and that it belongs to the currently logged in user
+func (a *API) WateringRuleCheck(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		c.Abort()
+		return
+	}
+
+	rule := models.WateringRule{}
+	err = a.DB.Get(&rule, "select * from watering_rules where id = $1", id)
+	if err != nil {
+		BadRequest(c, "Watering rule does not exist ("+err.Error()+")")
+		c.Abort()
+		return
+	}
+
+	if rule.UserID != c.GetInt("user_id") {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "you don't own that watering rule",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("watering_rule", &rule)
+}
+
+// wateringRuleInput binds and validates a watering rule from the request. Responds and returns false if it's invalid.
+func (a *API) wateringRuleInput(c *gin.Context, rule *models.WateringRule) bool {
+	id, userID, createdAt, blocked := rule.ID, rule.UserID, rule.CreatedAt, rule.BlockedReason
+
+	if err := c.BindJSON(rule); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	// These can't be changed
+	rule.ID, rule.UserID, rule.CreatedAt, rule.BlockedReason = id, userID, createdAt, blocked
+
+	if rule.Name == "" {
+		a.error(c, http.StatusBadRequest, "Watering rules must have a name")
+		return false
+	} else if rule.MoistureBelow <= 0 || rule.MoistureBelow > 100 {
+		a.error(c, http.StatusBadRequest, "moisture_below must be between 1 and 100")
+		return false
+	} else if rule.WaterVolume <= 0 {
+		a.error(c, http.StatusBadRequest, "water_volume must be positive")
+		return false
+	} else if rule.Cooldown < 0 || rule.MinHoursSinceWatering < 0 || rule.DailyCap < 1 {
+		a.error(c, http.StatusBadRequest, "cooldown and min_hours_since_watering can't be negative, and daily_cap must be at least 1")
+		return false
+	}
+
+	if ok, err := a.canUsePlant(c.GetInt("user_id"), rule.PlantID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return false
+	} else if !ok {
+		a.error(c, http.StatusForbidden, "You can't water that plant")
+		return false
+	}
+
+	if ok, err := a.canUseRobot(c.GetInt("user_id"), rule.RobotID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return false
+	} else if !ok {
+		a.error(c, http.StatusForbidden, "You can't use that robot")
+		return false
+	}
+
+	return true
+}
+
+// WateringRuleListGet lists the watering rules of the current user. Takes an optional "plant_id".
+func (a *API) WateringRuleListGet(c *gin.Context) {
+	rules := []models.WateringRule{}
+
+	err := a.DB.Select(&rules, "select * from watering_rules where user_id = $1 and ($2 = '' or plant_id::text = $2) order by created_at", c.GetInt("user_id"), c.Query("plant_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+	})
+}
+
+// WateringRuleCreatePost creates a watering rule
+func (a *API) WateringRuleCreatePost(c *gin.Context) {
+	rule := models.WateringRule{
+		Cooldown: 60,
+		DailyCap: 3,
+		Enabled:  true,
+	}
+
+	if !a.wateringRuleInput(c, &rule) {
+		return
+	}
+	rule.UserID = c.GetInt("user_id")
+
+	rows, err := a.DB.NamedQuery(
+		`insert into watering_rules(user_id, name, plant_id, robot_id, moisture_below, min_hours_since_watering, water_volume, cooldown, daily_cap, dry_run, enabled)
+		values (:user_id, :name, :plant_id, :robot_id, :moisture_below, :min_hours_since_watering, :water_volume, :cooldown, :daily_cap, :dry_run, :enabled) returning id`,
+		rule,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		a.error(c, http.StatusInternalServerError, "Expected rows.Next() to return true")
+		return
+	}
+
+	var id int
+	if err := rows.Scan(&id); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// WateringRuleGet gets the watering rule
+func (a *API) WateringRuleGet(c *gin.Context) {
+	rule := c.MustGet("watering_rule").(*models.WateringRule)
+	c.JSON(http.StatusOK, rule)
+}
+
+// WateringRulePut replaces the watering rule
+func (a *API) WateringRulePut(c *gin.Context) {
+	rule := *c.MustGet("watering_rule").(*models.WateringRule)
+
+	if !a.wateringRuleInput(c, &rule) {
+		return
+	}
+
+	_, err := a.DB.NamedExec(
+		`update watering_rules set name = :name, plant_id = :plant_id, robot_id = :robot_id, moisture_below = :moisture_below,
+		min_hours_since_watering = :min_hours_since_watering, water_volume = :water_volume, cooldown = :cooldown,
+		daily_cap = :daily_cap, dry_run = :dry_run, enabled = :enabled where id = :id`,
+		rule,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// WateringRuleDelete deletes the watering rule
+func (a *API) WateringRuleDelete(c *gin.Context) {
+	rule := c.MustGet("watering_rule").(*models.WateringRule)
+
+	if _, err := a.DB.Exec("delete from watering_rules where id = $1", rule.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// WateringRuleFiringListGet lists the times the watering rule fired, most recent first
+func (a *API) WateringRuleFiringListGet(c *gin.Context) {
+	rule := c.MustGet("watering_rule").(*models.WateringRule)
+
+	input := struct {
+		Limit  int `form:"limit,default=50"`
+		Offset int `form:"offset,default=0"`
+	}{}
+
+	if err := c.BindQuery(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	firings := []models.WateringRuleFiring{}
+	err := a.DB.Select(&firings, "select * from watering_rule_firings where rule_id = $1 order by created_at desc limit $2 offset $3", rule.ID, input.Limit, input.Offset)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"firings": firings,
+	})
+}
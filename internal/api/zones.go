@@ -0,0 +1,327 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/teamxiv/growbot-api/internal/models"
+)
+
+// mapPlant is where a plant is, as sent to robots
+type mapPlant struct {
+	ID        int      `json:"id" db:"id"`
+	Name      string   `json:"name" db:"name"`
+	ZoneID    *int     `json:"zone_id" db:"zone_id"`
+	PositionX *float64 `json:"position_x" db:"position_x"`
+	PositionY *float64 `json:"position_y" db:"position_y"`
+	MarkerID  *int     `json:"marker_id" db:"marker_id"`
+}
+
+// sendPlantMap sends PLANT_MAP to the robot (if it is connected), with the zones and plants it looks after:
+// those of its owner, and those shared with its household.
+func (a *API) sendPlantMap(rid uuid.UUID) {
+	if !isRobotConnected(rid) {
+		return
+	}
+
+	robot := models.Robot{}
+	if err := a.DB.Get(&robot, "select user_id, household_id from robots where id = $1", rid); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not get robot for PLANT_MAP")
+		return
+	} else if robot.UserID == nil {
+		return
+	}
+
+	where := "user_id = $1 or (household_id is not null and household_id = $2)"
+
+	zones := []models.Zone{}
+	if err := a.DB.Select(&zones, "select * from zones where "+where+" order by id", *robot.UserID, robot.HouseholdID); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not get zones for PLANT_MAP")
+		return
+	}
+
+	plants := []mapPlant{}
+	if err := a.DB.Select(&plants, "select id, name, zone_id, position_x, position_y, marker_id from plants where ("+where+") and archived_at is null order by id", *robot.UserID, robot.HouseholdID); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not get plants for PLANT_MAP")
+		return
+	}
+
+	payload := struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{
+		Type: "PLANT_MAP",
+		Data: gin.H{
+			"zones":  zones,
+			"plants": plants,
+		},
+	}
+
+	if _, err := writeRobot(rid, payload); err != nil {
+		a.Log.WithError(err).WithField("rid", rid).Warnln("Could not send PLANT_MAP")
+	}
+}
+
+// pingPlantMaps sends the plant map again to the robots of the owner, and of the household
+func (a *API) pingPlantMaps(ownerID int, householdID *int) {
+	rids := []uuid.UUID{}
+	err := a.DB.Select(&rids, "select id from robots where user_id = $1 or (household_id is not null and household_id = $2)", ownerID, householdID)
+	if err != nil {
+		a.Log.WithError(err).WithField("user_id", ownerID).Warnln("Could not get robots to send PLANT_MAP to")
+		return
+	}
+
+	for _, rid := range rids {
+		a.sendPlantMap(rid)
+	}
+}
+
+// ZoneCheck is a middleware to check whether the passed zone exists,
+// and that the currently logged in user owns it, or is a member of the household it is shared with
+func (a *API) ZoneCheck(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		BadRequest(c, err.Error())
+		c.Abort()
+		return
+	}
+
+	zone := models.Zone{}
+	err = a.DB.Get(&zone, "select * from zones where id = $1", id)
+	if err != nil {
+		BadRequest(c, "Zone does not exist ("+err.Error()+")")
+		c.Abort()
+		return
+	}
+
+	if !a.accessCheck(c, &zone.UserID, zone.HouseholdID, "zone") {
+		c.Abort()
+		return
+	}
+
+	c.Set("zone", &zone)
+}
+
+// ZoneListGet lists the zones the user owns or that are shared with their households
+func (a *API) ZoneListGet(c *gin.Context) {
+	zones := []models.Zone{}
+
+	err := a.DB.Select(&zones, "select * from zones where user_id = $1 or household_id in "+sqlMyHouseholds+" order by name", c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"zones": zones,
+	})
+}
+
+// ZoneCreatePost creates a zone. Takes a "name", and optionally a "household_id" to share it with.
+func (a *API) ZoneCreatePost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	input := struct {
+		Name        string `json:"name"`
+		HouseholdID *int   `json:"household_id"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Zones must have a name")
+		return
+	}
+
+	if hid := input.HouseholdID; hid != nil {
+		role, err := a.householdRole(userID, *hid)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if role == "" || role == models.HouseholdRoleViewer {
+			a.error(c, http.StatusForbidden, "You can't share things with that household")
+			return
+		}
+	}
+
+	var id int
+	err := a.DB.Get(&id, "insert into zones(user_id, household_id, name) values ($1, $2, $3) returning id", userID, input.HouseholdID, input.Name)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.pingPlantMaps(userID, input.HouseholdID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// ZoneGet gets the zone, along with the plants in it
+func (a *API) ZoneGet(c *gin.Context) {
+	zone := c.MustGet("zone").(*models.Zone)
+
+	plants := []models.Plant{}
+	err := a.DB.Select(&plants, "select * from plants where zone_id = $1 and (user_id = $2 or household_id in (select household_id from household_members where user_id = $2)) and archived_at is null order by id", zone.ID, c.GetInt("user_id"))
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"zone":   zone,
+		"plants": plants,
+	})
+}
+
+// ZoneRenamePatch renames the zone. Takes a "name".
+func (a *API) ZoneRenamePatch(c *gin.Context) {
+	zone := c.MustGet("zone").(*models.Zone)
+
+	input := struct {
+		Name string `json:"name"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		a.error(c, http.StatusBadRequest, "Zones must have a name")
+		return
+	}
+
+	if _, err := a.DB.Exec("update zones set name = $2 where id = $1", zone.ID, input.Name); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.pingPlantMaps(zone.UserID, zone.HouseholdID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ZoneDelete deletes the zone. The plants in it are kept, without a zone or position.
+func (a *API) ZoneDelete(c *gin.Context) {
+	zone := c.MustGet("zone").(*models.Zone)
+
+	if !a.ownerCheck(c, &zone.UserID, "zone") {
+		return
+	}
+
+	tx, err := a.DB.Beginx()
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	// Positions are relative to the zone, so they don't mean anything without it
+	if _, err := tx.Exec("update plants set position_x = null, position_y = null where zone_id = $1", zone.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := tx.Exec("delete from zones where id = $1", zone.ID); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.pingPlantMaps(zone.UserID, zone.HouseholdID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ZoneHouseholdPut shares the zone with a household. Takes a "household_id", which can be null to stop sharing it.
+func (a *API) ZoneHouseholdPut(c *gin.Context) {
+	zone := c.MustGet("zone").(*models.Zone)
+	a.setHousehold(c, "zones", zone.ID, &zone.UserID)
+}
+
+// PlantLocationPut sets where the plant is. Takes a "zone_id", a "position_x" and "position_y"
+// (in metres within the zone) and a "marker_id" of the fiducial marker next to the plant.
+// All of these can be null.
+//
+// The zone has to belong to the owner of the plant, or be shared with the same household as the plant.
+func (a *API) PlantLocationPut(c *gin.Context) {
+	plant := c.MustGet("plant").(*models.Plant)
+
+	input := struct {
+		ZoneID    *int     `json:"zone_id"`
+		PositionX *float64 `json:"position_x"`
+		PositionY *float64 `json:"position_y"`
+		MarkerID  *int     `json:"marker_id"`
+	}{}
+
+	if err := c.BindJSON(&input); err != nil {
+		a.error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if (input.PositionX == nil) != (input.PositionY == nil) {
+		a.error(c, http.StatusBadRequest, "position_x and position_y must be set together")
+		return
+	} else if input.PositionX != nil && input.ZoneID == nil {
+		a.error(c, http.StatusBadRequest, "Plants need a zone to have a position")
+		return
+	} else if input.MarkerID != nil && *input.MarkerID < 0 {
+		a.error(c, http.StatusBadRequest, "marker_id can't be negative")
+		return
+	}
+
+	if input.ZoneID != nil {
+		var ok bool
+		err := a.DB.Get(&ok, "select exists(select 1 from zones where id = $1 and (user_id = $2 or (household_id is not null and household_id = $3)))", *input.ZoneID, plant.UserID, plant.HouseholdID)
+		if err != nil {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		} else if !ok {
+			a.error(c, http.StatusBadRequest, "Zone does not exist")
+			return
+		}
+	}
+
+	if input.MarkerID != nil {
+		var other int
+		err := a.DB.Get(&other, "select id from plants where user_id = $1 and marker_id = $2 and id != $3", plant.UserID, *input.MarkerID, plant.ID)
+		if err == nil {
+			a.error(c, http.StatusConflict, "That marker is already used by plant "+strconv.Itoa(other))
+			return
+		} else if err != sql.ErrNoRows {
+			a.error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	_, err := a.DB.Exec(
+		"update plants set zone_id = $2, position_x = $3, position_y = $4, marker_id = $5 where id = $1",
+		plant.ID, input.ZoneID, input.PositionX, input.PositionY, input.MarkerID,
+	)
+	if err != nil {
+		a.error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.pingPlantMaps(plant.UserID, plant.HouseholdID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Config struct {
 	LogLevel string `default:"debug"`
@@ -12,8 +16,68 @@ type Config struct {
 
 	// Static Robot UUID (stage 1 only)
 	UUID uuid.UUID `required:"true"`
+
+	// Where the web frontend lives, used to build links sent in emails
+	FrontendURL string `default:"http://localhost:3000"`
+
+	// Where this API can be reached from the outside, used to build download links
+	PublicURL string `default:"http://localhost:8080"`
+
+	// How long a finished data export can be downloaded for
+	ExportExpiry time.Duration `default:"48h"`
+
+	// How long deleted plants, events and photos are kept (and can be restored) before they are gone for good
+	ArchiveRetention time.Duration `default:"720h"`
+
+	Mail MailConfig
+
+	// OpenID Connect providers that users can sign in with
+	OIDC []OIDCProviderConfig
+
+	// Rate limiting of the authentication endpoints
+	RateLimit RateLimitConfig
 }
 
 type DatabaseConfig struct {
 	ConnectionString string `required:"true"`
 }
+
+type MailConfig struct {
+	// SMTP server in host:port form. If empty, emails are written to the log instead of being sent.
+	SMTPAddress string
+
+	Username string
+	Password string
+
+	From string `default:"GrowBot <growbot@localhost>"`
+}
+
+type RateLimitConfig struct {
+	// Requests allowed per IP address per minute, for each rate limited endpoint
+	PerIP int `default:"30"`
+
+	// Failed password attempts allowed (per account, or per IP address) before locking out
+	MaxFailures int `default:"5"`
+
+	// How long the first lockout lasts. Every failure after that doubles it, up to MaxLockout.
+	Lockout    time.Duration `default:"1m"`
+	MaxLockout time.Duration `default:"1h"`
+}
+
+type OIDCProviderConfig struct {
+	// Name identifies the provider in login URLs, e.g. /auth/oidc/<name>
+	Name string
+
+	// Issuer is the URL used for discovery ("<issuer>/.well-known/openid-configuration")
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is where the provider sends the user back to, usually a page on the frontend
+	// that posts the code and state to /auth/oidc/<name>/callback
+	RedirectURL string
+
+	// Scopes requested in addition to "openid"
+	Scopes []string
+}
@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/teamxiv/growbot-api/internal/config"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// New returns a Mailer using the configured SMTP server.
+//
+// If no SMTP server is configured, emails are written to the log instead.
+func New(conf config.MailConfig, log *logrus.Logger) Mailer {
+	if conf.SMTPAddress == "" {
+		return &logMailer{log: log}
+	}
+
+	return &smtpMailer{conf: conf}
+}
+
+type smtpMailer struct {
+	conf config.MailConfig
+}
+
+// stripNewlines stops header injection through user provided values
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+func (m *smtpMailer) Send(to string, subject string, body string) error {
+	host, _, err := net.SplitHostPort(m.conf.SMTPAddress)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.conf.Username != "" {
+		auth = smtp.PlainAuth("", m.conf.Username, m.conf.Password, host)
+	}
+
+	msg := "From: " + stripNewlines(m.conf.From) + "\r\n" +
+		"To: " + stripNewlines(to) + "\r\n" +
+		"Subject: " + stripNewlines(subject) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	return smtp.SendMail(m.conf.SMTPAddress, auth, m.conf.From, []string{to}, []byte(msg))
+}
+
+type logMailer struct {
+	log *logrus.Logger
+}
+
+func (m *logMailer) Send(to string, subject string, body string) error {
+	m.log.WithFields(logrus.Fields{
+		"module":  "mail",
+		"to":      to,
+		"subject": subject,
+	}).Infoln(body)
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// How serious an alert is
+const (
+	AlertNone = iota
+	AlertWarning
+	AlertDanger
+)
+
+// RobotAlerts holds the thresholds (in percent) at which a robot's water tank and battery are
+// too low, and which alerts are currently raised
+type RobotAlerts struct {
+	RobotID uuid.UUID `json:"robot_id" db:"robot_id"`
+
+	WaterWarning   int `json:"water_warning" db:"water_warning"`
+	WaterDanger    int `json:"water_danger" db:"water_danger"`
+	BatteryWarning int `json:"battery_warning" db:"battery_warning"`
+	BatteryDanger  int `json:"battery_danger" db:"battery_danger"`
+
+	// Hysteresis is how far above a threshold a level has to rise before its alert is cleared
+	Hysteresis int `json:"hysteresis" db:"hysteresis"`
+
+	// PauseWatering holds back watering while the water alert is at AlertDanger,
+	// until the tank has been refilled
+	PauseWatering bool `json:"pause_watering" db:"pause_watering"`
+
+	WaterAlert     int  `json:"water_alert" db:"water_alert"`
+	BatteryAlert   int  `json:"battery_alert" db:"battery_alert"`
+	WateringPaused bool `json:"watering_paused" db:"watering_paused"`
+}
+
+// AlertLevel returns the alert for a level, given the alert currently raised.
+//
+// Alerts are raised as soon as the level reaches a threshold, but are only lowered
+// once the level is more than hysteresis above it, so that they don't flap.
+func AlertLevel(current int, level int, warning int, danger int, hysteresis int) int {
+	switch {
+	case level <= danger:
+		return AlertDanger
+	case current == AlertDanger && level <= danger+hysteresis:
+		return AlertDanger
+	case level <= warning:
+		return AlertWarning
+	case current != AlertNone && level <= warning+hysteresis:
+		return AlertWarning
+	}
+	return AlertNone
+}
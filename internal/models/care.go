@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// CareProfile describes how a kind of plant should be looked after.
+//
+// Profiles without a UserID are part of the built-in catalog, everyone else's are their own.
+type CareProfile struct {
+	ID      int     `json:"id" db:"id"`
+	UserID  *int    `json:"user_id,omitempty" db:"user_id"`
+	Name    string  `json:"name" db:"name"`
+	Species *string `json:"species,omitempty" db:"species"`
+
+	// MinMoisture and MaxMoisture are the target soil moisture range, in percent
+	MinMoisture int `json:"min_moisture" db:"min_moisture"`
+	MaxMoisture int `json:"max_moisture" db:"max_moisture"`
+
+	// WaterVolume is how much to water at a time, in millilitres
+	WaterVolume int    `json:"water_volume" db:"water_volume"`
+	Light       string `json:"light" db:"light"`
+
+	// PhotoInterval is how often a photo of the plant should be taken, in hours
+	PhotoInterval int `json:"photo_interval" db:"photo_interval"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+const (
+	LightLow            = "low"
+	LightMedium         = "medium"
+	LightBrightIndirect = "bright_indirect"
+	LightFullSun        = "full_sun"
+)
+
+// ValidLight returns whether the light level is one we know about
+func ValidLight(light string) bool {
+	return light == LightLow || light == LightMedium || light == LightBrightIndirect || light == LightFullSun
+}
+
+const (
+	MoistureUnknown = "unknown"
+	MoistureDry     = "dry"
+	MoistureOK      = "ok"
+	MoistureWet     = "wet"
+)
+
+// MoistureStatus compares a soil moisture reading against the target range of the profile
+func (p *CareProfile) MoistureStatus(moisture *int) string {
+	if p == nil || moisture == nil {
+		return MoistureUnknown
+	} else if *moisture < p.MinMoisture {
+		return MoistureDry
+	} else if *moisture > p.MaxMoisture {
+		return MoistureWet
+	}
+	return MoistureOK
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QuietHoursLayout is the format of the start and end of quiet hours
+const QuietHoursLayout = "15:04"
+
+// RobotDocking holds when a robot has to stay on its dock, and how charged it has to be to go out
+type RobotDocking struct {
+	RobotID uuid.UUID `json:"robot_id" db:"robot_id"`
+
+	// QuietStart and QuietEnd are the daily quiet hours (as QuietHoursLayout, in Timezone),
+	// during which the robot stays docked. Both are nil if it has no quiet hours.
+	// The quiet hours wrap around midnight if QuietEnd is before QuietStart.
+	QuietStart *string `json:"quiet_start" db:"quiet_start"`
+	QuietEnd   *string `json:"quiet_end" db:"quiet_end"`
+	Timezone   string  `json:"timezone" db:"timezone"`
+
+	// MinBattery is the battery level (in percent) below which the robot isn't sent anywhere
+	MinBattery int `json:"min_battery" db:"min_battery"`
+}
+
+// InQuietHours returns whether t falls within the quiet hours
+func (d *RobotDocking) InQuietHours(t time.Time) (bool, error) {
+	if d.QuietStart == nil || d.QuietEnd == nil {
+		return false, nil
+	}
+
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return false, err
+	}
+
+	start, err := time.Parse(QuietHoursLayout, *d.QuietStart)
+	if err != nil {
+		return false, err
+	}
+
+	end, err := time.Parse(QuietHoursLayout, *d.QuietEnd)
+	if err != nil {
+		return false, err
+	}
+
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	if from <= to {
+		return from <= now && now < to, nil
+	}
+	return now >= from || now < to, nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// PhotoAnalysisStatus is how far the analysis of a plant photo has got
+const (
+	PhotoAnalysisPending = "pending"
+	PhotoAnalysisRunning = "running"
+	PhotoAnalysisDone    = "done"
+	PhotoAnalysisFailed  = "failed"
+)
+
+// GrowthPoint is the canopy of a plant in one of its photos
+type GrowthPoint struct {
+	PhotoID        int       `json:"photo_id" db:"id"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	CanopyPixels   int       `json:"canopy_pixels" db:"canopy_pixels"`
+	CanopyFraction float64   `json:"canopy_fraction" db:"canopy_fraction"`
+	ColourHealth   *int      `json:"colour_health" db:"colour_health"`
+}
+
+// GrowthRate is the slope of the least squares line through the canopy fractions of the points,
+// in fraction of the photo per day. Returns nil with fewer than two points, or if they were all taken at once.
+func GrowthRate(points []GrowthPoint) *float64 {
+	if len(points) < 2 {
+		return nil
+	}
+
+	start := points[0].CreatedAt
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := p.CreatedAt.Sub(start).Hours() / 24
+		sumX += x
+		sumY += p.CanopyFraction
+		sumXY += x * p.CanopyFraction
+		sumXX += x * x
+	}
+
+	n := float64(len(points))
+	denominator := n*sumXX - sumX*sumX
+	if math.Abs(denominator) < 1e-9 {
+		return nil
+	}
+
+	rate := (n*sumXY - sumX*sumY) / denominator
+	return &rate
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// What a plant's health is made up of
+const (
+	HealthFactorMoisture = "moisture"
+	HealthFactorWatering = "watering"
+	HealthFactorGrowth   = "growth"
+)
+
+// HealthFactor is one part of a plant's health, scored from 0 to 100
+type HealthFactor struct {
+	Factor string `json:"factor"`
+	Score  int    `json:"score"`
+
+	// Weight is how much the factor counts towards the overall score
+	Weight  int    `json:"weight"`
+	Message string `json:"message"`
+}
+
+// HealthScore is the weighted average of the factors, or nil if there are none
+func HealthScore(factors []HealthFactor) *int {
+	total, weights := 0, 0
+	for _, f := range factors {
+		total += f.Score * f.Weight
+		weights += f.Weight
+	}
+
+	if weights == 0 {
+		return nil
+	}
+
+	score := int(math.Round(float64(total) / float64(weights)))
+	return &score
+}
+
+// MoistureHealth scores the soil moisture against the target range of the profile.
+// Every percent outside the range takes off 4 points.
+func MoistureHealth(profile *CareProfile, moisture *int) *HealthFactor {
+	if profile == nil || moisture == nil {
+		return nil
+	}
+
+	f := HealthFactor{Factor: HealthFactorMoisture, Weight: 40, Score: 100}
+
+	off := 0
+	switch profile.MoistureStatus(moisture) {
+	case MoistureDry:
+		off = profile.MinMoisture - *moisture
+		f.Message = fmt.Sprintf("Soil moisture is %d%%, below the target of %d-%d%%", *moisture, profile.MinMoisture, profile.MaxMoisture)
+	case MoistureWet:
+		off = *moisture - profile.MaxMoisture
+		f.Message = fmt.Sprintf("Soil moisture is %d%%, above the target of %d-%d%%", *moisture, profile.MinMoisture, profile.MaxMoisture)
+	default:
+		f.Message = fmt.Sprintf("Soil moisture is %d%%, within the target of %d-%d%%", *moisture, profile.MinMoisture, profile.MaxMoisture)
+	}
+
+	f.Score = clampScore(100 - off*4)
+	return &f
+}
+
+// WateringHealth scores how regularly the plant has been watered, from its waterings in order.
+// Uneven gaps between waterings and failed waterings both lower the score.
+func WateringHealth(waterings []PlantWatering) *HealthFactor {
+	if len(waterings) == 0 {
+		return nil
+	}
+
+	f := HealthFactor{Factor: HealthFactorWatering, Weight: 30, Score: 100}
+
+	var times []time.Time
+	for _, w := range waterings {
+		if w.Success {
+			times = append(times, w.CreatedAt)
+		}
+	}
+
+	failed := len(waterings) - len(times)
+	f.Message = fmt.Sprintf("Watered %d times recently", len(times))
+
+	// Gaps are only worth comparing once there are a few of them
+	if len(times) >= 3 {
+		gaps := make([]float64, len(times)-1)
+		mean := 0.0
+		for i := range gaps {
+			gaps[i] = times[i+1].Sub(times[i]).Hours()
+			mean += gaps[i]
+		}
+		mean /= float64(len(gaps))
+
+		variance := 0.0
+		for _, g := range gaps {
+			variance += (g - mean) * (g - mean)
+		}
+		variance /= float64(len(gaps))
+
+		// The coefficient of variation is 0 for perfectly even gaps
+		cv := 1.0
+		if mean > 0 {
+			cv = math.Min(math.Sqrt(variance)/mean, 1)
+		}
+
+		f.Score = clampScore(int(math.Round(100 * (1 - cv))))
+		if cv > 0.5 {
+			f.Message += ", at irregular intervals"
+		} else {
+			f.Message += ", about every " + formatHours(mean)
+		}
+	}
+
+	if failed > 0 {
+		f.Score = f.Score * len(times) / len(waterings)
+		f.Message += fmt.Sprintf(", and %d waterings failed", failed)
+	}
+
+	return &f
+}
+
+// GrowthHealth scores the trend in green coverage across photos of the plant, oldest first.
+// Steady or growing coverage scores 100, and halving it (or worse) scores 0.
+func GrowthHealth(coverage []float64) *HealthFactor {
+	if len(coverage) < 2 || coverage[0] <= 0 {
+		return nil
+	}
+
+	first, last := coverage[0], coverage[len(coverage)-1]
+	change := (last - first) / first
+
+	f := HealthFactor{Factor: HealthFactorGrowth, Weight: 30, Score: 100}
+	if change < 0 {
+		f.Score = clampScore(int(math.Round(100 + change*200)))
+		f.Message = fmt.Sprintf("Green coverage has shrunk from %.0f%% to %.0f%% of the photo", first*100, last*100)
+	} else {
+		f.Message = fmt.Sprintf("Green coverage has grown from %.0f%% to %.0f%% of the photo", first*100, last*100)
+	}
+
+	return &f
+}
+
+func clampScore(score int) int {
+	if score < 0 {
+		return 0
+	} else if score > 100 {
+		return 100
+	}
+	return score
+}
+
+func formatHours(hours float64) string {
+	if hours < 48 {
+		return fmt.Sprintf("%.0f hours", hours)
+	}
+	return fmt.Sprintf("%.0f days", hours/24)
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Household is a group of users sharing robots, plants and events
+type Household struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// HouseholdMember is the membership of a user in a household
+type HouseholdMember struct {
+	HouseholdID int       `json:"household_id" db:"household_id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	Role        string    `json:"role" db:"role"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// HouseholdInvite is an invitation to join a household.
+//
+// Invites with an email address can only be accepted once, by the user with that address.
+// Invites without one are shareable links, and can be used until they expire.
+type HouseholdInvite struct {
+	ID          int       `json:"id" db:"id"`
+	HouseholdID int       `json:"household_id" db:"household_id"`
+	Email       *string   `json:"email" db:"email"`
+	Role        string    `json:"role" db:"role"`
+	TokenHash   string    `json:"-" db:"token_hash"`
+	InvitedBy   int       `json:"invited_by" db:"invited_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+const (
+	// HouseholdRoleOwner can do everything, including managing members
+	HouseholdRoleOwner = "owner"
+
+	// HouseholdRoleGardener can use and change shared robots, plants and events
+	HouseholdRoleGardener = "gardener"
+
+	// HouseholdRoleViewer can only look
+	HouseholdRoleViewer = "viewer"
+)
+
+// ValidHouseholdRole returns whether the role is one we know about
+func ValidHouseholdRole(role string) bool {
+	return role == HouseholdRoleOwner || role == HouseholdRoleGardener || role == HouseholdRoleViewer
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	MapFormatPGM = "pgm"
+	MapFormatPNG = "png"
+)
+
+// RobotMap is a version of the occupancy grid built by a robot. The image itself is kept in the bucket.
+type RobotMap struct {
+	ID       int       `json:"id" db:"id"`
+	RobotID  uuid.UUID `json:"robot_id" db:"robot_id"`
+	Version  int       `json:"version" db:"version"`
+	Filename uuid.UUID `json:"-" db:"filename"`
+	Format   string    `json:"format" db:"format"`
+
+	// Resolution is in metres per pixel, and the origin is where the bottom left pixel is, in metres
+	Resolution float64 `json:"resolution" db:"resolution"`
+	OriginX    float64 `json:"origin_x" db:"origin_x"`
+	OriginY    float64 `json:"origin_y" db:"origin_y"`
+	Width      int     `json:"width" db:"width"`
+	Height     int     `json:"height" db:"height"`
+
+	// Annotations are MapAnnotations
+	Annotations types.JSONText `json:"annotations" db:"annotations"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// MapAnnotations are what users have marked on a map, in metres
+type MapAnnotations struct {
+	NoGo   []NoGoZone      `json:"no_go"`
+	Plants []MapPlantPoint `json:"plants"`
+}
+
+// NoGoZone is an area the robot must stay out of
+type NoGoZone struct {
+	Name   string       `json:"name"`
+	Points [][2]float64 `json:"points"`
+}
+
+// MapPlantPoint is where a plant is on the map
+type MapPlantPoint struct {
+	PlantID int     `json:"plant_id"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 )
 
 type Plant struct {
@@ -11,6 +13,41 @@ type Plant struct {
 	Name         string `json:"name" db:"name"`
 	UserID       int    `json:"user_id" db:"user_id"`
 	SoilMoisture *int   `json:"soil_moisture" db:"soil_moisture"`
+	HouseholdID  *int   `json:"household_id,omitempty" db:"household_id"`
+
+	CareProfileID *int `json:"care_profile_id" db:"care_profile_id"`
+
+	Tags pq.StringArray `json:"tags" db:"tags"`
+
+	// Fields are user-defined, as a JSON object of strings, numbers and booleans
+	Fields types.JSONText `json:"fields" db:"fields"`
+
+	// ZoneID is the room the plant is in. The position is in metres within the zone,
+	// and MarkerID is the fiducial marker placed next to the plant, if any.
+	ZoneID    *int     `json:"zone_id" db:"zone_id"`
+	PositionX *float64 `json:"position_x" db:"position_x"`
+	PositionY *float64 `json:"position_y" db:"position_y"`
+	MarkerID  *int     `json:"marker_id" db:"marker_id"`
+
+	// Health is a score from 0 to 100, explained by its HealthFactors. It is null until there is enough to go on.
+	Health        *int           `json:"health" db:"health"`
+	HealthFactors types.JSONText `json:"health_factors" db:"health_factors"`
+	HealthAt      *time.Time     `json:"health_at" db:"health_at"`
+
+	// ArchivedAt is set when the plant has been deleted. It is deleted for good once the retention period is over.
+	ArchivedAt *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+}
+
+// Zone is a room (or any other area) that plants are grouped in
+type Zone struct {
+	ID     int    `json:"id" db:"id"`
+	UserID int    `json:"user_id" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+
+	// HouseholdID is set when the zone is shared with a household
+	HouseholdID *int `json:"household_id,omitempty" db:"household_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 type PlantPhoto struct {
@@ -18,4 +55,59 @@ type PlantPhoto struct {
 	Filename  uuid.UUID `json:"filename" db:"filename"`
 	PlantID   int       `json:"plant_id" db:"plant_id"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// GreenCoverage is the fraction of the photo that is green, or null if it couldn't be worked out
+	GreenCoverage *float64 `json:"green_coverage" db:"green_coverage"`
+
+	// The rest is filled in by the analysis of the photo, see PhotoAnalysisStatus
+	AnalysisStatus string     `json:"analysis_status" db:"analysis_status"`
+	AnalysedAt     *time.Time `json:"analysed_at" db:"analysed_at"`
+	Width          *int       `json:"width" db:"width"`
+	Height         *int       `json:"height" db:"height"`
+
+	// CanopyPixels is how many pixels of the photo are plant, and CanopyFraction how much of the photo that is
+	CanopyPixels   *int     `json:"canopy_pixels" db:"canopy_pixels"`
+	CanopyFraction *float64 `json:"canopy_fraction" db:"canopy_fraction"`
+
+	// GreenFraction and YellowFraction are how much of the canopy is green and yellow
+	GreenFraction  *float64 `json:"green_fraction" db:"green_fraction"`
+	YellowFraction *float64 `json:"yellow_fraction" db:"yellow_fraction"`
+
+	// ColourHealth scores the colour of the canopy from 0 to 100, or is null if no plant was found in the photo
+	ColourHealth *int `json:"colour_health" db:"colour_health"`
+
+	// ArchivedAt is set when the photo has been deleted
+	ArchivedAt *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+}
+
+// JournalEntry is a note about a plant, optionally with one of its photos
+type JournalEntry struct {
+	ID      int    `json:"id" db:"id"`
+	PlantID int    `json:"plant_id" db:"plant_id"`
+	UserID  *int   `json:"user_id" db:"user_id"`
+	Body    string `json:"body" db:"body"`
+	PhotoID *int   `json:"photo_id" db:"photo_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+const (
+	PlantIdentifierQR  = "qr"
+	PlantIdentifierNFC = "nfc"
+)
+
+// PlantIdentifier is a QR code or NFC tag placed on a plant's pot, so that robots can tell which plant it is
+type PlantIdentifier struct {
+	ID      int    `json:"id" db:"id"`
+	PlantID int    `json:"plant_id" db:"plant_id"`
+	Kind    string `json:"kind" db:"kind"`
+
+	// UserID is the owner of the plant. Each owner can only bind a tag to one of their plants.
+	UserID int `json:"user_id" db:"user_id"`
+
+	// Value is the payload of the QR code, or the UID of the NFC tag (in upper case hex, without separators)
+	Value string `json:"value" db:"value"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
@@ -13,6 +13,9 @@ type Robot struct {
 	UserID     *int      `json:"user_id,omitempty" db:"user_id"`
 	Title      *string   `json:"title,omitempty" db:"title"`
 
+	// HouseholdID is set when the robot is shared with a household
+	HouseholdID *int `json:"household_id,omitempty" db:"household_id"`
+
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -29,5 +32,19 @@ type RobotState struct {
 	WaterLevel   int       `json:"water_level" db:"water_level"`
 	Standby      bool      `json:"standby" db:"standby"`
 
+	// TankCapacity is the size of the water tank in millilitres, as reported by the robot. 0 if unknown.
+	TankCapacity int `json:"tank_capacity" db:"tank_capacity"`
+
+	// WaterLevelAt is when the robot last reported its water level
+	WaterLevelAt *time.Time `json:"water_level_at" db:"water_level_at"`
+
+	// BatteryLevelAt is when the robot last reported its battery level, or null if it never has
+	BatteryLevelAt *time.Time `json:"battery_level_at" db:"battery_level_at"`
+
+	// Docked and Charging are reported by the robot. DockedAt is when it last docked.
+	Docked   bool       `json:"docked" db:"docked"`
+	Charging bool       `json:"charging" db:"charging"`
+	DockedAt *time.Time `json:"docked_at" db:"docked_at"`
+
 	SeenAt *time.Time `json:"seen_at" db:"seen_at"`
 }
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// What a step of a route can do. Watering and photos are the same as the event actions.
+const (
+	RouteStepVisitPlant   = "VISIT_PLANT"
+	RouteStepPhoto        = EventActionPlantCapturePhoto
+	RouteStepWater        = EventActionPlantWater
+	RouteStepReturnToDock = "RETURN_TO_DOCK"
+)
+
+// Route is an ordered list of steps for a robot to carry out in one go
+type Route struct {
+	ID     int    `json:"id" db:"id"`
+	UserID int    `json:"user_id" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+
+	// Steps are RouteSteps
+	Steps types.JSONText `json:"steps" db:"steps"`
+
+	// HouseholdID is set when the route is shared with a household
+	HouseholdID *int `json:"household_id,omitempty" db:"household_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RouteStep is a single step of a route. Steps with a condition are skipped by the robot if it doesn't hold.
+type RouteStep struct {
+	Action  string         `json:"action"`
+	PlantID *int           `json:"plant_id,omitempty"`
+	Data    types.JSONText `json:"data,omitempty"`
+	If      *StepCondition `json:"if,omitempty"`
+}
+
+// StepCondition is checked by the robot against the plant of the step, once it is there.
+// MoistureStatus is judged against the range sent with the step in its RoutePlanStep.
+type StepCondition struct {
+	MoistureBelow  *int   `json:"moisture_below,omitempty"`
+	MoistureStatus string `json:"moisture_status,omitempty"`
+}
+
+// RoutePlanStep is a step of a route as it is sent to a robot. Step is its position in the route, counting from 0,
+// and the moisture range of its plant is included when the step has a moisture_status condition.
+type RoutePlanStep struct {
+	Step int `json:"step"`
+	RouteStep
+
+	MinMoisture *int `json:"min_moisture,omitempty"`
+	MaxMoisture *int `json:"max_moisture,omitempty"`
+}
+
+const (
+	RouteRunRunning   = "running"
+	RouteRunCompleted = "completed"
+	RouteRunFailed    = "failed"
+	RouteRunAborted   = "aborted"
+)
+
+const (
+	RouteStepDone    = "done"
+	RouteStepSkipped = "skipped"
+	RouteStepFailed  = "failed"
+)
+
+// RouteRun is a robot going through a route
+type RouteRun struct {
+	ID          int       `json:"id" db:"id"`
+	RouteID     int       `json:"route_id" db:"route_id"`
+	RobotID     uuid.UUID `json:"robot_id" db:"robot_id"`
+	EventID     *int      `json:"event_id" db:"event_id"`
+	Status      string    `json:"status" db:"status"`
+	CurrentStep int       `json:"current_step" db:"current_step"`
+
+	// Progress are RouteStepProgress, in the order they were reported
+	Progress types.JSONText `json:"progress" db:"progress"`
+
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+// RouteStepProgress is what happened with a step of a route run
+type RouteStepProgress struct {
+	Step    int       `json:"step"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	At      time.Time `json:"at"`
+}
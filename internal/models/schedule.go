@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/lib/pq"
 
 	"github.com/google/uuid"
@@ -14,6 +16,10 @@ type Event struct {
 	Recurrences pq.StringArray `json:"recurrences" db:"recurrence"`
 	UserID      int            `json:"user_id" db:"user_id"`
 	Ephemeral   bool           `json:"ephemeral,omitempty" db:"ephemeral"`
+	HouseholdID *int           `json:"household_id,omitempty" db:"household_id"`
+
+	// ArchivedAt is set when the event has been deleted. Archived events aren't sent to robots.
+	ArchivedAt *time.Time `json:"archived_at,omitempty" db:"archived_at"`
 }
 
 type EventAction struct {
@@ -29,4 +35,7 @@ const (
 	EventActionPlantWater        = "PLANT_WATER"
 	EventActionPlantCapturePhoto = "PLANT_CAPTURE_PHOTO"
 	EventActionRobotRandomMove   = "ROBOT_RANDOM_MOVE"
+
+	// EventActionRunRoute runs a route. Its data is {"route_id": id}.
+	EventActionRunRoute = "RUN_ROUTE"
 )
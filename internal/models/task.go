@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	TaskStatusClaimed   = "claimed"
+	TaskStatusCompleted = "completed"
+	TaskStatusFailed    = "failed"
+	TaskStatusExpired   = "expired"
+)
+
+// TaskLease is a claim by a robot to carry out an action on a plant, so that no other robot does it too.
+//
+// The robot has to keep sending heartbeats until it is done, otherwise the lease expires
+// and the task is handed to another robot.
+type TaskLease struct {
+	ID      int       `json:"id" db:"id"`
+	PlantID int       `json:"plant_id" db:"plant_id"`
+	Name    string    `json:"name" db:"name"`
+	RobotID uuid.UUID `json:"robot_id" db:"robot_id"`
+	EventID *int      `json:"event_id" db:"event_id"`
+
+	// ScheduledAt is when the event was due, which together with EventID tells occurrences of an event apart
+	ScheduledAt *time.Time `json:"scheduled_at" db:"scheduled_at"`
+
+	// Data is the data of the action, so that it can be handed over as is
+	Data types.JSONText `json:"data" db:"data"`
+
+	Status      string     `json:"status" db:"status"`
+	ClaimedAt   time.Time  `json:"claimed_at" db:"claimed_at"`
+	HeartbeatAt time.Time  `json:"heartbeat_at" db:"heartbeat_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	FinishedAt  *time.Time `json:"finished_at" db:"finished_at"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AccessToken is a long-lived personal access token used for automation.
+//
+// Only a hash of the token is stored, the plaintext token is shown to the user once on creation.
+type AccessToken struct {
+	ID         int            `json:"id" db:"id"`
+	UserID     int            `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	TokenHash  string         `json:"-" db:"token_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time     `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+const (
+	ScopeRobotsRead    = "robots:read"
+	ScopeRobotsControl = "robots:control"
+	ScopePlantsRead    = "plants:read"
+	ScopePlantsWrite   = "plants:write"
+	ScopeEventsRead    = "events:read"
+	ScopeEventsWrite   = "events:write"
+	ScopeLogRead       = "log:read"
+)
+
+// Scopes is the list of all scopes that can be granted to an access token
+var Scopes = []string{
+	ScopeRobotsRead,
+	ScopeRobotsControl,
+	ScopePlantsRead,
+	ScopePlantsWrite,
+	ScopeEventsRead,
+	ScopeEventsWrite,
+	ScopeLogRead,
+}
+
+// ValidScope returns whether the scope is one we know about
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope returns whether the token has been granted the scope
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
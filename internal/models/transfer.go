@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RobotTransfer is a pending handover of a robot to another user
+type RobotTransfer struct {
+	ID         int       `json:"id" db:"id"`
+	RobotID    uuid.UUID `json:"robot_id" db:"robot_id"`
+	FromUserID int       `json:"from_user_id" db:"from_user_id"`
+	Email      string    `json:"email" db:"email"`
+	Mode       string    `json:"mode" db:"mode"`
+	TokenHash  string    `json:"-" db:"token_hash"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+const (
+	// TransferModeMigrate hands over the robot along with its events and settings
+	TransferModeMigrate = "migrate"
+
+	// TransferModeClear hands over the robot as if it was new
+	TransferModeClear = "clear"
+)
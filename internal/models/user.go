@@ -7,10 +7,20 @@ type User struct {
 	ID        int    `json:"id" db:"id"`
 	Forename  string `json:"forename" db:"forename"`
 	Surname   string `json:"surname" db:"surname"`
-	Password  string `json:"password" db:"password"`
+	Password  string `json:"-" db:"password"`
 	Email     string `json:"email" db:"email"`
 	Activated bool   `json:"is_activated" db:"is_activated"`
+	Role      string `json:"role" db:"role"`
 
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
+
+const (
+	// UserRoleUser is the role of everyone who signs up
+	UserRoleUser = "user"
+
+	// UserRoleAdmin is the role of support staff, who can use the /admin routes.
+	// There is no endpoint to make someone an admin, that has to be done in the database.
+	UserRoleAdmin = "admin"
+)
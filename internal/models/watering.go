@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WateringRule waters a plant with a robot whenever its soil gets too dry
+type WateringRule struct {
+	ID      int       `json:"id" db:"id"`
+	UserID  int       `json:"user_id" db:"user_id"`
+	Name    string    `json:"name" db:"name"`
+	PlantID int       `json:"plant_id" db:"plant_id"`
+	RobotID uuid.UUID `json:"robot_id" db:"robot_id"`
+
+	// MoistureBelow is the soil moisture (in percent) under which the rule fires
+	MoistureBelow int `json:"moisture_below" db:"moisture_below"`
+
+	// MinHoursSinceWatering stops the rule from firing if the plant was watered recently
+	MinHoursSinceWatering int `json:"min_hours_since_watering" db:"min_hours_since_watering"`
+
+	// WaterVolume is how much to water, in millilitres
+	WaterVolume int `json:"water_volume" db:"water_volume"`
+
+	// Cooldown is the minimum number of minutes between firings
+	Cooldown int `json:"cooldown" db:"cooldown"`
+
+	// DailyCap is the most times the rule can fire in a (UTC) day
+	DailyCap int `json:"daily_cap" db:"daily_cap"`
+
+	// DryRun rules only record that they would have fired
+	DryRun  bool `json:"dry_run" db:"dry_run"`
+	Enabled bool `json:"enabled" db:"enabled"`
+
+	// BlockedReason is why the rule last couldn't water the plant, cleared once it fires again
+	BlockedReason *string `json:"blocked_reason" db:"blocked_reason"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// WateringRuleFiring is a record of a watering rule firing
+type WateringRuleFiring struct {
+	ID          int       `json:"id" db:"id"`
+	RuleID      int       `json:"rule_id" db:"rule_id"`
+	PlantID     int       `json:"plant_id" db:"plant_id"`
+	RobotID     uuid.UUID `json:"robot_id" db:"robot_id"`
+	Moisture    int       `json:"moisture" db:"moisture"`
+	WaterVolume int       `json:"water_volume" db:"water_volume"`
+	DryRun      bool      `json:"dry_run" db:"dry_run"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// PlantWatering is a watering of a plant, as reported by the robot that did it
+type PlantWatering struct {
+	ID      int        `json:"id" db:"id"`
+	PlantID int        `json:"plant_id" db:"plant_id"`
+	RobotID *uuid.UUID `json:"robot_id" db:"robot_id"`
+
+	// Volume is how much water was dispensed, in millilitres
+	Volume int `json:"volume" db:"volume"`
+
+	// Duration is how long the watering took, in milliseconds
+	Duration int  `json:"duration" db:"duration"`
+	Success  bool `json:"success" db:"success"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// WaterUsage is how much water was dispensed over a day or a week
+type WaterUsage struct {
+	Period    time.Time `json:"period" db:"period"`
+	Volume    int       `json:"volume" db:"volume"`
+	Waterings int       `json:"waterings" db:"waterings"`
+	Failures  int       `json:"failures" db:"failures"`
+}
@@ -15,6 +15,20 @@ SET check_function_bodies = false;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: care_light; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.care_light AS ENUM (
+    'low',
+    'medium',
+    'bright_indirect',
+    'full_sun'
+);
+
+
+ALTER TYPE public.care_light OWNER TO growbot;
+
 --
 -- Name: event_action_name; Type: TYPE; Schema: public; Owner: growbot
 --
@@ -22,12 +36,129 @@ SET row_security = off;
 CREATE TYPE public.event_action_name AS ENUM (
     'PLANT_WATER',
     'PLANT_CAPTURE_PHOTO',
-    'ROBOT_RANDOM_MOVEMENT'
+    'ROBOT_RANDOM_MOVEMENT',
+    'RUN_ROUTE'
 );
 
 
 ALTER TYPE public.event_action_name OWNER TO growbot;
 
+--
+-- Name: household_role; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.household_role AS ENUM (
+    'owner',
+    'gardener',
+    'viewer'
+);
+
+
+ALTER TYPE public.household_role OWNER TO growbot;
+
+--
+-- Name: photo_analysis_status; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.photo_analysis_status AS ENUM (
+    'pending',
+    'running',
+    'done',
+    'failed'
+);
+
+
+ALTER TYPE public.photo_analysis_status OWNER TO growbot;
+
+--
+-- Name: plant_identifier_kind; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.plant_identifier_kind AS ENUM (
+    'qr',
+    'nfc'
+);
+
+
+ALTER TYPE public.plant_identifier_kind OWNER TO growbot;
+
+--
+-- Name: robot_map_format; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.robot_map_format AS ENUM (
+    'pgm',
+    'png'
+);
+
+
+ALTER TYPE public.robot_map_format OWNER TO growbot;
+
+--
+-- Name: robot_transfer_mode; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.robot_transfer_mode AS ENUM (
+    'migrate',
+    'clear'
+);
+
+
+ALTER TYPE public.robot_transfer_mode OWNER TO growbot;
+
+--
+-- Name: route_run_status; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.route_run_status AS ENUM (
+    'running',
+    'completed',
+    'failed',
+    'aborted'
+);
+
+
+ALTER TYPE public.route_run_status OWNER TO growbot;
+
+--
+-- Name: task_status; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.task_status AS ENUM (
+    'claimed',
+    'completed',
+    'failed',
+    'expired'
+);
+
+
+ALTER TYPE public.task_status OWNER TO growbot;
+
+--
+-- Name: user_role; Type: TYPE; Schema: public; Owner: growbot
+--
+
+CREATE TYPE public.user_role AS ENUM (
+    'user',
+    'admin'
+);
+
+
+ALTER TYPE public.user_role OWNER TO growbot;
+
+--
+-- Name: growbot_audit_log_append_only(); Type: FUNCTION; Schema: public; Owner: growbot
+--
+
+CREATE FUNCTION public.growbot_audit_log_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$begin
+	raise exception 'audit_log is append-only';
+end;$$;
+
+
+ALTER FUNCTION public.growbot_audit_log_append_only() OWNER TO growbot;
+
 --
 -- Name: growbot_create_state(); Type: FUNCTION; Schema: public; Owner: growbot
 --
@@ -46,6 +177,184 @@ SET default_tablespace = '';
 
 SET default_with_oids = false;
 
+--
+-- Name: access_tokens; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.access_tokens (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    name text NOT NULL,
+    token_hash text NOT NULL,
+    scopes text[] DEFAULT ARRAY[]::text[] NOT NULL,
+    expires_at timestamp without time zone,
+    last_used_at timestamp without time zone,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.access_tokens OWNER TO growbot;
+
+--
+-- Name: access_tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.access_tokens_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.access_tokens_id_seq OWNER TO growbot;
+
+--
+-- Name: access_tokens_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.access_tokens_id_seq OWNED BY public.access_tokens.id;
+
+
+--
+-- Name: audit_log; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.audit_log (
+    id integer NOT NULL,
+    actor_id integer,
+    credential text NOT NULL,
+    token_id integer,
+    ip text,
+    action text NOT NULL,
+    target_type text,
+    target_id text,
+    owner_id integer,
+    payload_digest text,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.audit_log OWNER TO growbot;
+
+--
+-- Name: TABLE audit_log; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.audit_log IS 'Append-only record of security-relevant and control actions. Unlike log, this is not shown as a feed, and rows outlive the users they mention.';
+
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.audit_log_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.audit_log_id_seq OWNER TO growbot;
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.audit_log_id_seq OWNED BY public.audit_log.id;
+
+
+--
+-- Name: care_profiles; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.care_profiles (
+    id integer NOT NULL,
+    user_id integer,
+    name text NOT NULL,
+    species text,
+    min_moisture integer NOT NULL,
+    max_moisture integer NOT NULL,
+    water_volume integer NOT NULL,
+    light public.care_light NOT NULL,
+    photo_interval integer NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    CONSTRAINT care_profiles_moisture_check CHECK (((min_moisture >= 0) AND (min_moisture <= max_moisture) AND (max_moisture <= 100)))
+);
+
+
+ALTER TABLE public.care_profiles OWNER TO growbot;
+
+--
+-- Name: TABLE care_profiles; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.care_profiles IS 'Care profiles with a null user_id are the built-in catalog, loaded by growbot-care-profiles';
+
+
+--
+-- Name: care_profiles_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.care_profiles_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.care_profiles_id_seq OWNER TO growbot;
+
+--
+-- Name: care_profiles_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.care_profiles_id_seq OWNED BY public.care_profiles.id;
+
+
+--
+-- Name: email_changes; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.email_changes (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    email character varying(254) NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.email_changes OWNER TO growbot;
+
+--
+-- Name: email_changes_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.email_changes_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.email_changes_id_seq OWNER TO growbot;
+
+--
+-- Name: email_changes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.email_changes_id_seq OWNED BY public.email_changes.id;
+
+
 --
 -- Name: event_actions; Type: TABLE; Schema: public; Owner: growbot
 --
@@ -60,474 +369,2102 @@ CREATE TABLE public.event_actions (
 );
 
 
-ALTER TABLE public.event_actions OWNER TO growbot;
+ALTER TABLE public.event_actions OWNER TO growbot;
+
+--
+-- Name: event_actions_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.event_actions_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.event_actions_id_seq OWNER TO growbot;
+
+--
+-- Name: event_actions_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.event_actions_id_seq OWNED BY public.event_actions.id;
+
+
+--
+-- Name: events; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.events (
+    id integer NOT NULL,
+    summary text NOT NULL,
+    recurrence text[] DEFAULT ARRAY[]::text[] NOT NULL,
+    user_id integer NOT NULL,
+    ephemeral boolean DEFAULT false NOT NULL,
+    household_id integer,
+    archived_at timestamp without time zone
+);
+
+
+ALTER TABLE public.events OWNER TO growbot;
+
+--
+-- Name: events_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.events_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.events_id_seq OWNER TO growbot;
+
+--
+-- Name: events_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.events_id_seq OWNED BY public.events.id;
+
+
+--
+-- Name: exports; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.exports (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    status text NOT NULL,
+    progress integer DEFAULT 0 NOT NULL,
+    filename uuid NOT NULL,
+    token_hash text,
+    error text,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    expires_at timestamp without time zone,
+    downloaded_at timestamp without time zone
+);
+
+
+ALTER TABLE public.exports OWNER TO growbot;
+
+--
+-- Name: exports_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.exports_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.exports_id_seq OWNER TO growbot;
+
+--
+-- Name: exports_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.exports_id_seq OWNED BY public.exports.id;
+
+
+--
+-- Name: household_invites; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.household_invites (
+    id integer NOT NULL,
+    household_id integer NOT NULL,
+    email text,
+    role public.household_role NOT NULL,
+    token_hash text NOT NULL,
+    invited_by integer NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    expires_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.household_invites OWNER TO growbot;
+
+--
+-- Name: household_invites_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.household_invites_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.household_invites_id_seq OWNER TO growbot;
+
+--
+-- Name: household_invites_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.household_invites_id_seq OWNED BY public.household_invites.id;
+
+
+--
+-- Name: household_members; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.household_members (
+    household_id integer NOT NULL,
+    user_id integer NOT NULL,
+    role public.household_role NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.household_members OWNER TO growbot;
+
+--
+-- Name: households; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.households (
+    id integer NOT NULL,
+    name text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.households OWNER TO growbot;
+
+--
+-- Name: households_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.households_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.households_id_seq OWNER TO growbot;
+
+--
+-- Name: households_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.households_id_seq OWNED BY public.households.id;
+
+
+--
+-- Name: log; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.log (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    type text NOT NULL,
+    message text NOT NULL,
+    severity integer NOT NULL,
+    robot_id uuid,
+    plant_id integer,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.log OWNER TO growbot;
+
+--
+-- Name: log_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.log_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.log_id_seq OWNER TO growbot;
+
+--
+-- Name: log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.log_id_seq OWNED BY public.log.id;
+
+
+--
+-- Name: plant_identifiers; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.plant_identifiers (
+    id integer NOT NULL,
+    plant_id integer NOT NULL,
+    user_id integer NOT NULL,
+    kind public.plant_identifier_kind NOT NULL,
+    value text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.plant_identifiers OWNER TO growbot;
+
+--
+-- Name: plant_identifiers_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.plant_identifiers_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.plant_identifiers_id_seq OWNER TO growbot;
+
+--
+-- Name: plant_identifiers_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.plant_identifiers_id_seq OWNED BY public.plant_identifiers.id;
+
+
+--
+-- Name: plant_journal; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.plant_journal (
+    id integer NOT NULL,
+    plant_id integer NOT NULL,
+    user_id integer,
+    body text NOT NULL,
+    photo_id integer,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.plant_journal OWNER TO growbot;
+
+--
+-- Name: plant_journal_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.plant_journal_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.plant_journal_id_seq OWNER TO growbot;
+
+--
+-- Name: plant_journal_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.plant_journal_id_seq OWNED BY public.plant_journal.id;
+
+
+--
+-- Name: plant_photos; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.plant_photos (
+    id integer NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    filename uuid NOT NULL,
+    plant_id integer NOT NULL,
+    green_coverage double precision,
+    archived_at timestamp without time zone,
+    analysis_status public.photo_analysis_status DEFAULT 'pending'::public.photo_analysis_status NOT NULL,
+    analysed_at timestamp without time zone,
+    width integer,
+    height integer,
+    canopy_pixels integer,
+    canopy_fraction double precision,
+    green_fraction double precision,
+    yellow_fraction double precision,
+    colour_health integer
+);
+
+
+ALTER TABLE public.plant_photos OWNER TO growbot;
+
+--
+-- Name: plant_photos_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.plant_photos_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.plant_photos_id_seq OWNER TO growbot;
+
+--
+-- Name: plant_photos_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.plant_photos_id_seq OWNED BY public.plant_photos.id;
+
+
+--
+-- Name: plant_waterings; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.plant_waterings (
+    id integer NOT NULL,
+    plant_id integer NOT NULL,
+    robot_id uuid,
+    volume integer NOT NULL,
+    duration integer NOT NULL,
+    success boolean NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.plant_waterings OWNER TO growbot;
+
+--
+-- Name: TABLE plant_waterings; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.plant_waterings IS 'volume is in millilitres, duration in milliseconds';
+
+
+--
+-- Name: plant_waterings_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.plant_waterings_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.plant_waterings_id_seq OWNER TO growbot;
+
+--
+-- Name: plant_waterings_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.plant_waterings_id_seq OWNED BY public.plant_waterings.id;
+
+
+--
+-- Name: plants; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.plants (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    name text NOT NULL,
+    soil_moisture integer,
+    household_id integer,
+    care_profile_id integer,
+    tags text[] DEFAULT '{}'::text[] NOT NULL,
+    fields jsonb DEFAULT '{}'::jsonb NOT NULL,
+    zone_id integer,
+    position_x double precision,
+    position_y double precision,
+    marker_id integer,
+    health integer,
+    health_factors jsonb DEFAULT '[]'::jsonb NOT NULL,
+    health_at timestamp without time zone,
+    archived_at timestamp without time zone
+);
+
+
+ALTER TABLE public.plants OWNER TO growbot;
+
+--
+-- Name: plants_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.plants_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.plants_id_seq OWNER TO growbot;
+
+--
+-- Name: plants_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.plants_id_seq OWNED BY public.plants.id;
+
+
+--
+-- Name: robot_alerts; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_alerts (
+    robot_id uuid NOT NULL,
+    water_warning integer DEFAULT 25 NOT NULL,
+    water_danger integer DEFAULT 10 NOT NULL,
+    battery_warning integer DEFAULT 25 NOT NULL,
+    battery_danger integer DEFAULT 10 NOT NULL,
+    hysteresis integer DEFAULT 5 NOT NULL,
+    pause_watering boolean DEFAULT true NOT NULL,
+    water_alert integer DEFAULT 0 NOT NULL,
+    battery_alert integer DEFAULT 0 NOT NULL,
+    watering_paused boolean DEFAULT false NOT NULL,
+    CONSTRAINT robot_alerts_thresholds_check CHECK (((0 <= water_danger) AND (water_danger < water_warning) AND (water_warning <= 100) AND (0 <= battery_danger) AND (battery_danger < battery_warning) AND (battery_warning <= 100) AND (hysteresis >= 0)))
+);
+
+
+ALTER TABLE public.robot_alerts OWNER TO growbot;
+
+--
+-- Name: robot_decommissions; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_decommissions (
+    robot_id uuid NOT NULL,
+    requested_by integer,
+    requested_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    sent_at timestamp without time zone,
+    completed_at timestamp without time zone
+);
+
+
+ALTER TABLE public.robot_decommissions OWNER TO growbot;
+
+--
+-- Name: robot_docking; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_docking (
+    robot_id uuid NOT NULL,
+    quiet_start text,
+    quiet_end text,
+    timezone text DEFAULT 'UTC'::text NOT NULL,
+    min_battery integer DEFAULT 20 NOT NULL,
+    CONSTRAINT robot_docking_quiet_hours_check CHECK ((((quiet_start IS NULL) = (quiet_end IS NULL)) AND ((min_battery >= 0) AND (min_battery <= 100))))
+);
+
+
+ALTER TABLE public.robot_docking OWNER TO growbot;
+
+--
+-- Name: robot_maps; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_maps (
+    id integer NOT NULL,
+    robot_id uuid NOT NULL,
+    version integer NOT NULL,
+    filename uuid NOT NULL,
+    format public.robot_map_format NOT NULL,
+    resolution double precision NOT NULL,
+    origin_x double precision DEFAULT 0 NOT NULL,
+    origin_y double precision DEFAULT 0 NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    annotations jsonb DEFAULT '{}'::jsonb NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.robot_maps OWNER TO growbot;
+
+--
+-- Name: TABLE robot_maps; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.robot_maps IS 'resolution is in metres per pixel, and the origin is where the bottom left pixel is, in metres';
+
+
+--
+-- Name: robot_maps_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.robot_maps_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.robot_maps_id_seq OWNER TO growbot;
+
+--
+-- Name: robot_maps_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.robot_maps_id_seq OWNED BY public.robot_maps.id;
+
+
+--
+-- Name: robot_state; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_state (
+    id uuid NOT NULL,
+    battery_level integer DEFAULT 0 NOT NULL,
+    water_level integer DEFAULT 0 NOT NULL,
+    standby boolean DEFAULT true NOT NULL,
+    seen_at timestamp without time zone,
+    tank_capacity integer DEFAULT 0 NOT NULL,
+    water_level_at timestamp without time zone,
+    docked boolean DEFAULT false NOT NULL,
+    charging boolean DEFAULT false NOT NULL,
+    docked_at timestamp without time zone,
+    battery_level_at timestamp without time zone
+);
+
+
+ALTER TABLE public.robot_state OWNER TO growbot;
+
+--
+-- Name: TABLE robot_state; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.robot_state IS 'All fields are mandatory, except that seen_at has the special case where if it''s `null`, the robot has not been "seen" yet. That is to say, the robot hasn''t been turned on yet.
+
+This is so that the interactive interfaces can report "Not seen yet" instead of just the default (blank) values.';
+
+
+--
+-- Name: robot_transfers; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robot_transfers (
+    id integer NOT NULL,
+    robot_id uuid NOT NULL,
+    from_user_id integer NOT NULL,
+    email text NOT NULL,
+    mode public.robot_transfer_mode NOT NULL,
+    token_hash text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    expires_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.robot_transfers OWNER TO growbot;
+
+--
+-- Name: robot_transfers_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.robot_transfers_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.robot_transfers_id_seq OWNER TO growbot;
+
+--
+-- Name: robot_transfers_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.robot_transfers_id_seq OWNED BY public.robot_transfers.id;
+
+
+--
+-- Name: robots; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.robots (
+    id uuid NOT NULL,
+    admin_token text NOT NULL,
+    user_id integer,
+    title text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    household_id integer
+);
+
+
+ALTER TABLE public.robots OWNER TO growbot;
+
+--
+-- Name: route_runs; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.route_runs (
+    id integer NOT NULL,
+    route_id integer NOT NULL,
+    robot_id uuid NOT NULL,
+    event_id integer,
+    status public.route_run_status DEFAULT 'running'::public.route_run_status NOT NULL,
+    current_step integer DEFAULT 0 NOT NULL,
+    progress jsonb DEFAULT '[]'::jsonb NOT NULL,
+    started_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    finished_at timestamp without time zone
+);
+
+
+ALTER TABLE public.route_runs OWNER TO growbot;
+
+--
+-- Name: route_runs_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.route_runs_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.route_runs_id_seq OWNER TO growbot;
+
+--
+-- Name: route_runs_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.route_runs_id_seq OWNED BY public.route_runs.id;
+
+
+--
+-- Name: routes; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.routes (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    household_id integer,
+    name text NOT NULL,
+    steps jsonb NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.routes OWNER TO growbot;
+
+--
+-- Name: routes_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.routes_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.routes_id_seq OWNER TO growbot;
+
+--
+-- Name: routes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.routes_id_seq OWNED BY public.routes.id;
+
+
+--
+-- Name: task_leases; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.task_leases (
+    id integer NOT NULL,
+    plant_id integer NOT NULL,
+    name public.event_action_name NOT NULL,
+    robot_id uuid NOT NULL,
+    event_id integer,
+    scheduled_at timestamp without time zone,
+    data jsonb DEFAULT '{}'::jsonb NOT NULL,
+    status public.task_status DEFAULT 'claimed'::public.task_status NOT NULL,
+    claimed_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    heartbeat_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    finished_at timestamp without time zone
+);
+
+
+ALTER TABLE public.task_leases OWNER TO growbot;
+
+--
+-- Name: TABLE task_leases; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.task_leases IS 'event_id is not a foreign key, as ephemeral events are removed once sent';
+
+
+--
+-- Name: task_leases_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.task_leases_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.task_leases_id_seq OWNER TO growbot;
+
+--
+-- Name: task_leases_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.task_leases_id_seq OWNED BY public.task_leases.id;
+
+
+--
+-- Name: user_identities; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.user_identities (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.user_identities OWNER TO growbot;
+
+--
+-- Name: user_identities_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.user_identities_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.user_identities_id_seq OWNER TO growbot;
+
+--
+-- Name: user_identities_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.user_identities_id_seq OWNED BY public.user_identities.id;
+
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    forename text NOT NULL,
+    surname text NOT NULL,
+    password character(60) NOT NULL,
+    email character varying(254) NOT NULL,
+    is_activated boolean DEFAULT false NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    role public.user_role DEFAULT 'user'::public.user_role NOT NULL
+);
+
+
+ALTER TABLE public.users OWNER TO growbot;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.users_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.users_id_seq OWNER TO growbot;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+
+
+--
+-- Name: watering_rule_firings; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.watering_rule_firings (
+    id integer NOT NULL,
+    rule_id integer NOT NULL,
+    plant_id integer NOT NULL,
+    robot_id uuid NOT NULL,
+    moisture integer NOT NULL,
+    water_volume integer NOT NULL,
+    dry_run boolean NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.watering_rule_firings OWNER TO growbot;
+
+--
+-- Name: watering_rule_firings_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.watering_rule_firings_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.watering_rule_firings_id_seq OWNER TO growbot;
+
+--
+-- Name: watering_rule_firings_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.watering_rule_firings_id_seq OWNED BY public.watering_rule_firings.id;
+
+
+--
+-- Name: watering_rules; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.watering_rules (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    name text NOT NULL,
+    plant_id integer NOT NULL,
+    robot_id uuid NOT NULL,
+    moisture_below integer NOT NULL,
+    min_hours_since_watering integer DEFAULT 0 NOT NULL,
+    water_volume integer NOT NULL,
+    cooldown integer DEFAULT 60 NOT NULL,
+    daily_cap integer DEFAULT 3 NOT NULL,
+    dry_run boolean DEFAULT false NOT NULL,
+    enabled boolean DEFAULT true NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
+    blocked_reason text
+);
+
+
+ALTER TABLE public.watering_rules OWNER TO growbot;
+
+--
+-- Name: TABLE watering_rules; Type: COMMENT; Schema: public; Owner: growbot
+--
+
+COMMENT ON TABLE public.watering_rules IS 'cooldown is in minutes, daily_cap is the most firings per (UTC) day';
+
+
+--
+-- Name: watering_rules_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.watering_rules_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.watering_rules_id_seq OWNER TO growbot;
+
+--
+-- Name: watering_rules_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.watering_rules_id_seq OWNED BY public.watering_rules.id;
+
+
+--
+-- Name: zones; Type: TABLE; Schema: public; Owner: growbot
+--
+
+CREATE TABLE public.zones (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    household_id integer,
+    name text NOT NULL,
+    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
+);
+
+
+ALTER TABLE public.zones OWNER TO growbot;
+
+--
+-- Name: zones_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+--
+
+CREATE SEQUENCE public.zones_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.zones_id_seq OWNER TO growbot;
+
+--
+-- Name: zones_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+--
+
+ALTER SEQUENCE public.zones_id_seq OWNED BY public.zones.id;
+
+
+--
+-- Name: access_tokens id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.access_tokens ALTER COLUMN id SET DEFAULT nextval('public.access_tokens_id_seq'::regclass);
+
+
+--
+-- Name: audit_log id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.audit_log ALTER COLUMN id SET DEFAULT nextval('public.audit_log_id_seq'::regclass);
+
+
+--
+-- Name: care_profiles id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.care_profiles ALTER COLUMN id SET DEFAULT nextval('public.care_profiles_id_seq'::regclass);
+
+
+--
+-- Name: email_changes id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.email_changes ALTER COLUMN id SET DEFAULT nextval('public.email_changes_id_seq'::regclass);
+
+
+--
+-- Name: event_actions id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.event_actions ALTER COLUMN id SET DEFAULT nextval('public.event_actions_id_seq'::regclass);
+
+
+--
+-- Name: events id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.events ALTER COLUMN id SET DEFAULT nextval('public.events_id_seq'::regclass);
+
+
+--
+-- Name: exports id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.exports ALTER COLUMN id SET DEFAULT nextval('public.exports_id_seq'::regclass);
+
+
+--
+-- Name: household_invites id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.household_invites ALTER COLUMN id SET DEFAULT nextval('public.household_invites_id_seq'::regclass);
+
+
+--
+-- Name: households id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.households ALTER COLUMN id SET DEFAULT nextval('public.households_id_seq'::regclass);
+
+
+--
+-- Name: log id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.log ALTER COLUMN id SET DEFAULT nextval('public.log_id_seq'::regclass);
+
+
+--
+-- Name: plant_identifiers id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_identifiers ALTER COLUMN id SET DEFAULT nextval('public.plant_identifiers_id_seq'::regclass);
+
+
+--
+-- Name: plant_journal id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_journal ALTER COLUMN id SET DEFAULT nextval('public.plant_journal_id_seq'::regclass);
+
+
+--
+-- Name: plant_photos id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_photos ALTER COLUMN id SET DEFAULT nextval('public.plant_photos_id_seq'::regclass);
+
+
+--
+-- Name: plant_waterings id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_waterings ALTER COLUMN id SET DEFAULT nextval('public.plant_waterings_id_seq'::regclass);
+
+
+--
+-- Name: plants id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plants ALTER COLUMN id SET DEFAULT nextval('public.plants_id_seq'::regclass);
+
+
+--
+-- Name: robot_maps id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_maps ALTER COLUMN id SET DEFAULT nextval('public.robot_maps_id_seq'::regclass);
+
+
+--
+-- Name: robot_transfers id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_transfers ALTER COLUMN id SET DEFAULT nextval('public.robot_transfers_id_seq'::regclass);
+
+
+--
+-- Name: route_runs id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.route_runs ALTER COLUMN id SET DEFAULT nextval('public.route_runs_id_seq'::regclass);
+
+
+--
+-- Name: routes id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.routes ALTER COLUMN id SET DEFAULT nextval('public.routes_id_seq'::regclass);
+
+
+--
+-- Name: task_leases id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.task_leases ALTER COLUMN id SET DEFAULT nextval('public.task_leases_id_seq'::regclass);
+
+
+--
+-- Name: user_identities id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.user_identities ALTER COLUMN id SET DEFAULT nextval('public.user_identities_id_seq'::regclass);
+
+
+--
+-- Name: users id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
+
+
+--
+-- Name: watering_rule_firings id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.watering_rule_firings ALTER COLUMN id SET DEFAULT nextval('public.watering_rule_firings_id_seq'::regclass);
+
+
+--
+-- Name: watering_rules id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.watering_rules ALTER COLUMN id SET DEFAULT nextval('public.watering_rules_id_seq'::regclass);
+
+
+--
+-- Name: zones id; Type: DEFAULT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.zones ALTER COLUMN id SET DEFAULT nextval('public.zones_id_seq'::regclass);
+
+
+--
+-- Name: access_tokens access_tokens_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.access_tokens
+    ADD CONSTRAINT access_tokens_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: access_tokens access_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.access_tokens
+    ADD CONSTRAINT access_tokens_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: audit_log audit_log_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: care_profiles care_profiles_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.care_profiles
+    ADD CONSTRAINT care_profiles_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: email_changes email_changes_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.email_changes
+    ADD CONSTRAINT email_changes_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: email_changes email_changes_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.email_changes
+    ADD CONSTRAINT email_changes_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: event_actions event_actions_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.event_actions
+    ADD CONSTRAINT event_actions_id_key PRIMARY KEY (id);
+
+
+--
+-- Name: events events_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.events
+    ADD CONSTRAINT events_id_key PRIMARY KEY (id);
+
+
+--
+-- Name: exports exports_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.exports
+    ADD CONSTRAINT exports_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: exports exports_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.exports
+    ADD CONSTRAINT exports_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: household_invites household_invites_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.household_invites
+    ADD CONSTRAINT household_invites_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: household_invites household_invites_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.household_invites
+    ADD CONSTRAINT household_invites_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: household_members household_members_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.household_members
+    ADD CONSTRAINT household_members_pkey PRIMARY KEY (household_id, user_id);
+
+
+--
+-- Name: households households_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.households
+    ADD CONSTRAINT households_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: log log_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.log
+    ADD CONSTRAINT log_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: plant_identifiers plant_identifiers_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_identifiers
+    ADD CONSTRAINT plant_identifiers_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: plant_identifiers plant_identifiers_user_id_kind_value_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_identifiers
+    ADD CONSTRAINT plant_identifiers_user_id_kind_value_key UNIQUE (user_id, kind, value);
+
+
+--
+-- Name: plant_journal plant_journal_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_journal
+    ADD CONSTRAINT plant_journal_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: plant_photos plant_photos_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_photos
+    ADD CONSTRAINT plant_photos_id_key PRIMARY KEY (id);
+
+
+--
+-- Name: plant_waterings plant_waterings_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plant_waterings
+    ADD CONSTRAINT plant_waterings_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: plants plants_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.plants
+    ADD CONSTRAINT plants_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: robot_alerts robot_alerts_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_alerts
+    ADD CONSTRAINT robot_alerts_pkey PRIMARY KEY (robot_id);
+
+
+--
+-- Name: robot_decommissions robot_decommissions_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_decommissions
+    ADD CONSTRAINT robot_decommissions_pkey PRIMARY KEY (robot_id);
+
+
+--
+-- Name: robot_docking robot_docking_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_docking
+    ADD CONSTRAINT robot_docking_pkey PRIMARY KEY (robot_id);
+
+
+--
+-- Name: robot_maps robot_maps_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_maps
+    ADD CONSTRAINT robot_maps_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: robot_maps robot_maps_robot_id_version_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_maps
+    ADD CONSTRAINT robot_maps_robot_id_version_key UNIQUE (robot_id, version);
+
+
+--
+-- Name: robot_state robot_state_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_state
+    ADD CONSTRAINT robot_state_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: robot_transfers robot_transfers_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_transfers
+    ADD CONSTRAINT robot_transfers_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: robot_transfers robot_transfers_robot_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_transfers
+    ADD CONSTRAINT robot_transfers_robot_id_key UNIQUE (robot_id);
+
+
+--
+-- Name: robot_transfers robot_transfers_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robot_transfers
+    ADD CONSTRAINT robot_transfers_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: robots robots_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.robots
+    ADD CONSTRAINT robots_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: route_runs route_runs_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.route_runs
+    ADD CONSTRAINT route_runs_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: routes routes_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.routes
+    ADD CONSTRAINT routes_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: task_leases task_leases_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.task_leases
+    ADD CONSTRAINT task_leases_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_identities user_identities_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_identities user_identities_provider_subject_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_provider_subject_key UNIQUE (provider, subject);
+
+
+--
+-- Name: users users_email_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
+
+
+--
+-- Name: users users_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: watering_rule_firings watering_rule_firings_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.watering_rule_firings
+    ADD CONSTRAINT watering_rule_firings_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: watering_rules watering_rules_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.watering_rules
+    ADD CONSTRAINT watering_rules_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: zones zones_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.zones
+    ADD CONSTRAINT zones_id_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: audit_log_actor_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX audit_log_actor_id_idx ON public.audit_log USING btree (actor_id);
+
+
+--
+-- Name: audit_log_created_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX audit_log_created_at_idx ON public.audit_log USING btree (created_at);
+
+
+--
+-- Name: audit_log_owner_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX audit_log_owner_id_idx ON public.audit_log USING btree (owner_id);
+
+
+--
+-- Name: care_profiles_catalog_name_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE UNIQUE INDEX care_profiles_catalog_name_idx ON public.care_profiles USING btree (name) WHERE (user_id IS NULL);
+
+
+--
+-- Name: events_archived_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX events_archived_at_idx ON public.events USING btree (archived_at) WHERE (archived_at IS NOT NULL);
+
+
+--
+-- Name: plant_identifiers_plant_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_identifiers_plant_id_idx ON public.plant_identifiers USING btree (plant_id);
+
+
+--
+-- Name: plant_journal_plant_id_created_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_journal_plant_id_created_at_idx ON public.plant_journal USING btree (plant_id, created_at);
+
+
+--
+-- Name: plant_photos_analysis_status_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_photos_analysis_status_idx ON public.plant_photos USING btree (created_at) WHERE (analysis_status = 'pending'::public.photo_analysis_status);
+
+
+--
+-- Name: plant_photos_archived_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_photos_archived_at_idx ON public.plant_photos USING btree (archived_at) WHERE (archived_at IS NOT NULL);
+
+
+--
+-- Name: plant_waterings_plant_id_created_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_waterings_plant_id_created_at_idx ON public.plant_waterings USING btree (plant_id, created_at);
+
+
+--
+-- Name: plant_waterings_robot_id_created_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plant_waterings_robot_id_created_at_idx ON public.plant_waterings USING btree (robot_id, created_at);
+
+
+--
+-- Name: plants_archived_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plants_archived_at_idx ON public.plants USING btree (archived_at) WHERE (archived_at IS NOT NULL);
+
+
+--
+-- Name: plants_fields_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plants_fields_idx ON public.plants USING gin (fields);
+
+
+--
+-- Name: plants_name_user_id_key; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE UNIQUE INDEX plants_name_user_id_key ON public.plants USING btree (user_id, name) WHERE (archived_at IS NULL);
+
+
+--
+-- Name: plants_tags_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX plants_tags_idx ON public.plants USING gin (tags);
+
+
+--
+-- Name: plants_user_id_marker_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE UNIQUE INDEX plants_user_id_marker_id_idx ON public.plants USING btree (user_id, marker_id) WHERE (marker_id IS NOT NULL);
+
+
+--
+-- Name: route_runs_route_id_started_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX route_runs_route_id_started_at_idx ON public.route_runs USING btree (route_id, started_at);
+
+
+--
+-- Name: routes_user_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX routes_user_id_idx ON public.routes USING btree (user_id);
+
+
+--
+-- Name: task_leases_active_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE UNIQUE INDEX task_leases_active_idx ON public.task_leases USING btree (plant_id, name) WHERE (status = 'claimed'::public.task_status);
+
+
+--
+-- Name: task_leases_event_id_scheduled_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX task_leases_event_id_scheduled_at_idx ON public.task_leases USING btree (event_id, scheduled_at) WHERE (event_id IS NOT NULL);
+
+
+--
+-- Name: task_leases_plant_id_claimed_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX task_leases_plant_id_claimed_at_idx ON public.task_leases USING btree (plant_id, claimed_at);
+
+
+--
+-- Name: task_leases_robot_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX task_leases_robot_id_idx ON public.task_leases USING btree (robot_id) WHERE (status = 'claimed'::public.task_status);
+
+
+--
+-- Name: watering_rule_firings_rule_id_created_at_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX watering_rule_firings_rule_id_created_at_idx ON public.watering_rule_firings USING btree (rule_id, created_at);
+
+
+--
+-- Name: watering_rules_plant_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX watering_rules_plant_id_idx ON public.watering_rules USING btree (plant_id);
+
+
+--
+-- Name: zones_user_id_idx; Type: INDEX; Schema: public; Owner: growbot
+--
+
+CREATE INDEX zones_user_id_idx ON public.zones USING btree (user_id);
+
+
+--
+-- Name: audit_log trig_audit_log_append_only; Type: TRIGGER; Schema: public; Owner: growbot
+--
+
+CREATE TRIGGER trig_audit_log_append_only BEFORE DELETE OR UPDATE ON public.audit_log FOR EACH ROW EXECUTE PROCEDURE public.growbot_audit_log_append_only();
+
+
+--
+-- Name: robots trig_create_state; Type: TRIGGER; Schema: public; Owner: growbot
+--
+
+CREATE TRIGGER trig_create_state AFTER INSERT ON public.robots FOR EACH ROW EXECUTE PROCEDURE public.growbot_create_state();
+
+
+--
+-- Name: access_tokens access_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.access_tokens
+    ADD CONSTRAINT access_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: care_profiles care_profiles_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.care_profiles
+    ADD CONSTRAINT care_profiles_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: email_changes email_changes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.email_changes
+    ADD CONSTRAINT email_changes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: event_actions event_actions_event_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.event_actions
+    ADD CONSTRAINT event_actions_event_id_fkey FOREIGN KEY (event_id) REFERENCES public.events(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: event_actions event_actions_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+--
+
+ALTER TABLE ONLY public.event_actions
+    ADD CONSTRAINT event_actions_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
 
 --
--- Name: event_actions_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: event_actions event_actions_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.event_actions_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.event_actions
+    ADD CONSTRAINT event_actions_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.event_actions_id_seq OWNER TO growbot;
 
 --
--- Name: event_actions_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: events events_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.event_actions_id_seq OWNED BY public.event_actions.id;
+ALTER TABLE ONLY public.events
+    ADD CONSTRAINT events_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: events; Type: TABLE; Schema: public; Owner: growbot
+-- Name: events events_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.events (
-    id integer NOT NULL,
-    summary text NOT NULL,
-    recurrence text[] DEFAULT ARRAY[]::text[] NOT NULL,
-    user_id integer NOT NULL,
-    ephemeral boolean DEFAULT false NOT NULL
-);
-
+ALTER TABLE ONLY public.events
+    ADD CONSTRAINT events_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.events OWNER TO growbot;
 
 --
--- Name: events_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: exports exports_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.events_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.exports
+    ADD CONSTRAINT exports_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.events_id_seq OWNER TO growbot;
 
 --
--- Name: events_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: household_invites household_invites_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.events_id_seq OWNED BY public.events.id;
+ALTER TABLE ONLY public.household_invites
+    ADD CONSTRAINT household_invites_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log; Type: TABLE; Schema: public; Owner: growbot
+-- Name: household_invites household_invites_invited_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.log (
-    id integer NOT NULL,
-    user_id integer NOT NULL,
-    type text NOT NULL,
-    message text NOT NULL,
-    severity integer NOT NULL,
-    robot_id uuid,
-    plant_id integer,
-    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
-);
-
+ALTER TABLE ONLY public.household_invites
+    ADD CONSTRAINT household_invites_invited_by_fkey FOREIGN KEY (invited_by) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.log OWNER TO growbot;
 
 --
--- Name: log_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: household_members household_members_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.log_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.household_members
+    ADD CONSTRAINT household_members_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.log_id_seq OWNER TO growbot;
 
 --
--- Name: log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: household_members household_members_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.log_id_seq OWNED BY public.log.id;
+ALTER TABLE ONLY public.household_members
+    ADD CONSTRAINT household_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plant_photos; Type: TABLE; Schema: public; Owner: growbot
+-- Name: log log_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.plant_photos (
-    id integer NOT NULL,
-    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
-    filename uuid NOT NULL,
-    plant_id integer NOT NULL
-);
-
+ALTER TABLE ONLY public.log
+    ADD CONSTRAINT log_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.plant_photos OWNER TO growbot;
 
 --
--- Name: plant_photos_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: log log_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.plant_photos_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.log
+    ADD CONSTRAINT log_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.plant_photos_id_seq OWNER TO growbot;
 
 --
--- Name: plant_photos_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: log log_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.plant_photos_id_seq OWNED BY public.plant_photos.id;
+ALTER TABLE ONLY public.log
+    ADD CONSTRAINT log_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plants; Type: TABLE; Schema: public; Owner: growbot
+-- Name: plant_identifiers plant_identifiers_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.plants (
-    id integer NOT NULL,
-    user_id integer NOT NULL,
-    name text NOT NULL,
-    soil_moisture integer
-);
-
+ALTER TABLE ONLY public.plant_identifiers
+    ADD CONSTRAINT plant_identifiers_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.plants OWNER TO growbot;
 
 --
--- Name: plants_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: plant_identifiers plant_identifiers_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.plants_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.plant_identifiers
+    ADD CONSTRAINT plant_identifiers_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.plants_id_seq OWNER TO growbot;
 
 --
--- Name: plants_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: plant_journal plant_journal_photo_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.plants_id_seq OWNED BY public.plants.id;
+ALTER TABLE ONLY public.plant_journal
+    ADD CONSTRAINT plant_journal_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.plant_photos(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: robot_state; Type: TABLE; Schema: public; Owner: growbot
+-- Name: plant_journal plant_journal_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.robot_state (
-    id uuid NOT NULL,
-    battery_level integer DEFAULT 0 NOT NULL,
-    water_level integer DEFAULT 0 NOT NULL,
-    standby boolean DEFAULT true NOT NULL,
-    seen_at timestamp without time zone
-);
-
+ALTER TABLE ONLY public.plant_journal
+    ADD CONSTRAINT plant_journal_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.robot_state OWNER TO growbot;
 
 --
--- Name: TABLE robot_state; Type: COMMENT; Schema: public; Owner: growbot
+-- Name: plant_journal plant_journal_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-COMMENT ON TABLE public.robot_state IS 'All fields are mandatory, except that seen_at has the special case where if it''s `null`, the robot has not been "seen" yet. That is to say, the robot hasn''t been turned on yet.
-
-This is so that the interactive interfaces can report "Not seen yet" instead of just the default (blank) values.';
+ALTER TABLE ONLY public.plant_journal
+    ADD CONSTRAINT plant_journal_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: robots; Type: TABLE; Schema: public; Owner: growbot
+-- Name: plant_photos plant_photos_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.robots (
-    id uuid NOT NULL,
-    admin_token text NOT NULL,
-    user_id integer,
-    title text DEFAULT ''::text NOT NULL,
-    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
-    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
-);
-
+ALTER TABLE ONLY public.plant_photos
+    ADD CONSTRAINT plant_photos_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
-ALTER TABLE public.robots OWNER TO growbot;
 
 --
--- Name: users; Type: TABLE; Schema: public; Owner: growbot
+-- Name: plant_waterings plant_waterings_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TABLE public.users (
-    id integer NOT NULL,
-    forename text NOT NULL,
-    surname text NOT NULL,
-    password character(60) NOT NULL,
-    email character varying(254) NOT NULL,
-    is_activated boolean DEFAULT false NOT NULL,
-    created_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL,
-    updated_at timestamp without time zone DEFAULT timezone('utc'::text, now()) NOT NULL
-);
+ALTER TABLE ONLY public.plant_waterings
+    ADD CONSTRAINT plant_waterings_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
-ALTER TABLE public.users OWNER TO growbot;
-
 --
--- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: growbot
+-- Name: plant_waterings plant_waterings_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE SEQUENCE public.users_id_seq
-    AS integer
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
-
+ALTER TABLE ONLY public.plant_waterings
+    ADD CONSTRAINT plant_waterings_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
-ALTER TABLE public.users_id_seq OWNER TO growbot;
 
 --
--- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: growbot
+-- Name: plants plants_care_profile_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+ALTER TABLE ONLY public.plants
+    ADD CONSTRAINT plants_care_profile_id_fkey FOREIGN KEY (care_profile_id) REFERENCES public.care_profiles(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: event_actions id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: plants plants_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.event_actions ALTER COLUMN id SET DEFAULT nextval('public.event_actions_id_seq'::regclass);
+ALTER TABLE ONLY public.plants
+    ADD CONSTRAINT plants_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: events id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: plants plants_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.events ALTER COLUMN id SET DEFAULT nextval('public.events_id_seq'::regclass);
+ALTER TABLE ONLY public.plants
+    ADD CONSTRAINT plants_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: plants plants_zone_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.log ALTER COLUMN id SET DEFAULT nextval('public.log_id_seq'::regclass);
+ALTER TABLE ONLY public.plants
+    ADD CONSTRAINT plants_zone_id_fkey FOREIGN KEY (zone_id) REFERENCES public.zones(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: plant_photos id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: robot_alerts robot_alerts_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plant_photos ALTER COLUMN id SET DEFAULT nextval('public.plant_photos_id_seq'::regclass);
+ALTER TABLE ONLY public.robot_alerts
+    ADD CONSTRAINT robot_alerts_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plants id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: robot_decommissions robot_decommissions_requested_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plants ALTER COLUMN id SET DEFAULT nextval('public.plants_id_seq'::regclass);
+ALTER TABLE ONLY public.robot_decommissions
+    ADD CONSTRAINT robot_decommissions_requested_by_fkey FOREIGN KEY (requested_by) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: users id; Type: DEFAULT; Schema: public; Owner: growbot
+-- Name: robot_decommissions robot_decommissions_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
+ALTER TABLE ONLY public.robot_decommissions
+    ADD CONSTRAINT robot_decommissions_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: event_actions event_actions_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robot_docking robot_docking_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.event_actions
-    ADD CONSTRAINT event_actions_id_key PRIMARY KEY (id);
+ALTER TABLE ONLY public.robot_docking
+    ADD CONSTRAINT robot_docking_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events events_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robot_maps robot_maps_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.events
-    ADD CONSTRAINT events_id_key PRIMARY KEY (id);
+ALTER TABLE ONLY public.robot_maps
+    ADD CONSTRAINT robot_maps_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log log_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robot_state robot_state_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.log
-    ADD CONSTRAINT log_id_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.robot_state
+    ADD CONSTRAINT robot_state_id_fkey FOREIGN KEY (id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plant_photos plant_photos_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robot_transfers robot_transfers_from_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plant_photos
-    ADD CONSTRAINT plant_photos_id_key PRIMARY KEY (id);
+ALTER TABLE ONLY public.robot_transfers
+    ADD CONSTRAINT robot_transfers_from_user_id_fkey FOREIGN KEY (from_user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plants plants_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robot_transfers robot_transfers_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plants
-    ADD CONSTRAINT plants_id_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.robot_transfers
+    ADD CONSTRAINT robot_transfers_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plants plants_name_user_id_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robots robots_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plants
-    ADD CONSTRAINT plants_name_user_id_key UNIQUE (user_id, name);
+ALTER TABLE ONLY public.robots
+    ADD CONSTRAINT robots_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: robot_state robot_state_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: robots robots_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.robot_state
-    ADD CONSTRAINT robot_state_id_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.robots
+    ADD CONSTRAINT robots_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: robots robots_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: route_runs route_runs_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.robots
-    ADD CONSTRAINT robots_id_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.route_runs
+    ADD CONSTRAINT route_runs_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_email_key; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: route_runs route_runs_route_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE ONLY public.route_runs
+    ADD CONSTRAINT route_runs_route_id_fkey FOREIGN KEY (route_id) REFERENCES public.routes(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_id_pkey; Type: CONSTRAINT; Schema: public; Owner: growbot
+-- Name: routes routes_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_id_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.routes
+    ADD CONSTRAINT routes_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: robots trig_create_state; Type: TRIGGER; Schema: public; Owner: growbot
+-- Name: routes routes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-CREATE TRIGGER trig_create_state AFTER INSERT ON public.robots FOR EACH ROW EXECUTE PROCEDURE public.growbot_create_state();
+ALTER TABLE ONLY public.routes
+    ADD CONSTRAINT routes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: event_actions event_actions_event_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: task_leases task_leases_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.event_actions
-    ADD CONSTRAINT event_actions_event_id_fkey FOREIGN KEY (event_id) REFERENCES public.events(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.task_leases
+    ADD CONSTRAINT task_leases_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: event_actions event_actions_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: task_leases task_leases_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.event_actions
-    ADD CONSTRAINT event_actions_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.task_leases
+    ADD CONSTRAINT task_leases_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: event_actions event_actions_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: user_identities user_identities_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.event_actions
-    ADD CONSTRAINT event_actions_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events events_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rule_firings watering_rule_firings_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.events
-    ADD CONSTRAINT events_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rule_firings
+    ADD CONSTRAINT watering_rule_firings_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log log_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rule_firings watering_rule_firings_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.log
-    ADD CONSTRAINT log_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rule_firings
+    ADD CONSTRAINT watering_rule_firings_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log log_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rule_firings watering_rule_firings_rule_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.log
-    ADD CONSTRAINT log_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rule_firings
+    ADD CONSTRAINT watering_rule_firings_rule_id_fkey FOREIGN KEY (rule_id) REFERENCES public.watering_rules(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: log log_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rules watering_rules_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.log
-    ADD CONSTRAINT log_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rules
+    ADD CONSTRAINT watering_rules_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plant_photos plant_photos_plant_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rules watering_rules_robot_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plant_photos
-    ADD CONSTRAINT plant_photos_plant_id_fkey FOREIGN KEY (plant_id) REFERENCES public.plants(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rules
+    ADD CONSTRAINT watering_rules_robot_id_fkey FOREIGN KEY (robot_id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: plants plants_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: watering_rules watering_rules_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.plants
-    ADD CONSTRAINT plants_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.watering_rules
+    ADD CONSTRAINT watering_rules_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: robot_state robot_state_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: zones zones_household_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.robot_state
-    ADD CONSTRAINT robot_state_id_fkey FOREIGN KEY (id) REFERENCES public.robots(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.zones
+    ADD CONSTRAINT zones_household_id_fkey FOREIGN KEY (household_id) REFERENCES public.households(id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: robots robots_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
+-- Name: zones zones_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: growbot
 --
 
-ALTER TABLE ONLY public.robots
-    ADD CONSTRAINT robots_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.zones
+    ADD CONSTRAINT zones_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --